@@ -1,10 +1,253 @@
 package arangostore
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
 
 var (
 	// ErrNoCollection is returned when no collection is provided.
 	ErrNoCollection = fmt.Errorf("no collection provided")
 	// ErrNoDatabase is returned when no database is provided.
 	ErrNoDatabase = fmt.Errorf("no database provided")
+	// ErrInvalidGracePeriod is returned when a negative grace period is provided.
+	ErrInvalidGracePeriod = fmt.Errorf("invalid grace period provided")
+	// ErrNoClock is returned when no clock is provided.
+	ErrNoClock = fmt.Errorf("no clock provided")
+	// ErrTokenNotFound is returned when no token matches the lookup.
+	ErrTokenNotFound = fmt.Errorf("token not found")
+	// ErrClientNotFound is returned when no client matches the lookup.
+	ErrClientNotFound = fmt.Errorf("client not found")
+	// ErrRevisionMismatch is returned when a document was changed since it was
+	// read.
+	ErrRevisionMismatch = fmt.Errorf("revision mismatch")
+	// ErrInvalidLimit is returned when a non-positive page size is provided.
+	ErrInvalidLimit = fmt.Errorf("invalid limit provided")
+	// ErrNoSecretHasher is returned when no secret hasher is provided.
+	ErrNoSecretHasher = fmt.Errorf("no secret hasher provided")
+	// ErrInvalidHash is returned when a stored secret hash cannot be parsed.
+	ErrInvalidHash = fmt.Errorf("invalid hash")
+	// ErrInvalidHashParams is returned when a secret hasher is configured
+	// with parameters the hash function does not support.
+	ErrInvalidHashParams = fmt.Errorf("invalid hash parameters")
+	// ErrNoHashKey is returned when no key is provided for hashing tokens.
+	ErrNoHashKey = fmt.Errorf("no hash key provided")
+	// ErrNoEncryptor is returned when no encryptor is provided, or encrypted
+	// data is read without an encryptor configured.
+	ErrNoEncryptor = fmt.Errorf("no encryptor provided")
+	// ErrUnknownKey is returned when the encryption key is not known.
+	ErrUnknownKey = fmt.Errorf("unknown encryption key")
+	// ErrInvalidCiphertext is returned when the encrypted data is malformed.
+	ErrInvalidCiphertext = fmt.Errorf("invalid ciphertext")
+	// ErrInvalidTombstoneWindow is returned when a non-positive tombstone window
+	// is provided.
+	ErrInvalidTombstoneWindow = fmt.Errorf("invalid tombstone window provided")
+	// ErrRefreshTokenReuse is returned when a refresh token is presented that
+	// was already rotated.
+	ErrRefreshTokenReuse = fmt.Errorf("refresh token reuse detected")
+	// ErrCodeReuse is returned when an authorization code is presented that was
+	// already consumed.
+	ErrCodeReuse = fmt.Errorf("authorization code reuse detected")
+	// ErrInvalidCacheConfig is returned when an invalid cache size or TTL is
+	// provided.
+	ErrInvalidCacheConfig = fmt.Errorf("invalid cache configuration provided")
+	// ErrNoClientStore is returned when no client store is provided.
+	ErrNoClientStore = fmt.Errorf("no client store provided")
+	// ErrNoTokenStore is returned when no token store is provided.
+	ErrNoTokenStore = fmt.Errorf("no token store provided")
+	// ErrUnsupportedOperation is returned when the wrapped store does not
+	// support an operation.
+	ErrUnsupportedOperation = fmt.Errorf("operation not supported by the wrapped store")
+	// ErrInvalidTimeout is returned when a non-positive timeout is provided.
+	ErrInvalidTimeout = fmt.Errorf("invalid timeout provided")
+	// ErrInvalidRetryPolicy is returned when a retry policy without attempts
+	// or with negative or inconsistent backoffs is provided.
+	ErrInvalidRetryPolicy = fmt.Errorf("invalid retry policy provided")
 )
+
+// StoreError is returned by the stores when an operation fails. It tells the
+// operation and the collection, while the cause remains accessible using
+// errors.Is and errors.As.
+type StoreError struct {
+	// Op is the name of the failed operation, like GetByAccess.
+	Op string
+	// Collection is the name of the collection the operation used.
+	Collection string
+	// Cause is the error the operation failed with.
+	Cause error
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Collection, e.Cause)
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Cause
+}
+
+// wrapError wraps the error into a StoreError, unless it is nil or a
+// StoreError already, as returned by the operations an operation calls.
+func wrapError(op string, collection string, err *error) {
+	var storeErr *StoreError
+	if *err == nil || errors.As(*err, &storeErr) {
+		return
+	}
+
+	*err = &StoreError{Op: op, Collection: collection, Cause: *err}
+}
+
+// closeCursor closes the cursor, joining the error of closing it into err.
+func closeCursor(cursor arangoDriver.Cursor, err *error) {
+	closeErr := cursor.Close()
+	if closeErr == nil {
+		return
+	}
+
+	if *err == nil {
+		*err = closeErr
+		return
+	}
+
+	*err = fmt.Errorf("%w; close cursor: %v", *err, closeErr)
+}
+
+// sentinelError translates an error returned by the driver to one of the
+// errors of the package. It matches the given sentinel error when using
+// errors.Is, while keeping the original error accessible using errors.As.
+type sentinelError struct {
+	sentinel error
+	cause    error
+}
+
+func (e *sentinelError) Error() string {
+	return fmt.Sprintf("%s: %s", e.sentinel, e.cause)
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.cause
+}
+
+// withTimeout returns a context that is canceled after the timeout, unless the
+// context has a deadline already or the timeout is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout == 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// SchemaReport describes the changes made while ensuring the schema of a
+// collection.
+type SchemaReport struct {
+	// CollectionCreated is true if the collection was missing and got created.
+	CollectionCreated bool
+	// CreatedIndexes contains the names of the indexes that were created.
+	CreatedIndexes []string
+}
+
+// ensureCollection returns the collection with the given name, creating it if
+// it does not exist yet.
+func ensureCollection(ctx context.Context, db arangoDriver.Database, name string, report *SchemaReport) (arangoDriver.Collection, error) {
+	exists, err := db.CollectionExists(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return db.Collection(ctx, name)
+	}
+
+	coll, err := db.CreateCollection(ctx, name, nil)
+	if err != nil {
+		// The collection may have been created by someone else in the meantime.
+		if arangoDriver.IsConflict(err) {
+			return db.Collection(ctx, name)
+		}
+
+		return nil, err
+	}
+
+	report.CollectionCreated = true
+
+	return coll, nil
+}
+
+// ensurePersistentIndex ensures a persistent index exists on the given field.
+func ensurePersistentIndex(ctx context.Context, coll arangoDriver.Collection, field string, report *SchemaReport) error {
+	name := "idx_" + field
+
+	_, created, err := coll.EnsurePersistentIndex(ctx, []string{field}, &arangoDriver.EnsurePersistentIndexOptions{
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	if created {
+		report.CreatedIndexes = append(report.CreatedIndexes, name)
+	}
+
+	return nil
+}
+
+// ensureTTLIndex ensures a TTL index exists on the given field. Documents are
+// removed by ArangoDB once the date stored in the field is older than
+// expireAfter seconds.
+func ensureTTLIndex(ctx context.Context, coll arangoDriver.Collection, field string, expireAfter int, report *SchemaReport) error {
+	name := "ttl_" + field
+
+	_, created, err := coll.EnsureTTLIndex(ctx, field, expireAfter, &arangoDriver.EnsureTTLIndexOptions{
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	if created {
+		report.CreatedIndexes = append(report.CreatedIndexes, name)
+	}
+
+	return nil
+}
+
+// encodeData returns the data in the form it is stored in the documents. The
+// data is stored as nested JSON object if asObject is set, otherwise as base64
+// encoded string.
+func encodeData(data []byte, asObject bool) (json.RawMessage, error) {
+	if asObject {
+		return data, nil
+	}
+
+	return json.Marshal(data)
+}
+
+// decodeData returns the data stored in a document, regardless of whether it
+// was stored as base64 encoded string or as nested JSON object.
+func decodeData(raw json.RawMessage) ([]byte, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '"' {
+		if bytes.Equal(raw, []byte("null")) {
+			return nil, nil
+		}
+
+		return raw, nil
+	}
+
+	var data []byte
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
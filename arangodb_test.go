@@ -1,8 +1,15 @@
 package arangostore
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
 
 	arangoDriver "github.com/arangodb/go-driver"
 	"github.com/stretchr/testify/mock"
@@ -343,6 +350,10 @@ func (m *MockArangoCollection) EnsureHashIndex(ctx context.Context, fields []str
 
 func (m *MockArangoCollection) EnsurePersistentIndex(ctx context.Context, fields []string, options *arangoDriver.EnsurePersistentIndexOptions) (arangoDriver.Index, bool, error) {
 	args := m.Called(ctx, fields, options)
+	if args.Get(0) == nil {
+		return nil, args.Bool(1), args.Error(2)
+	}
+
 	return args.Get(0).(arangoDriver.Index), args.Bool(1), args.Error(2)
 }
 
@@ -353,6 +364,10 @@ func (m *MockArangoCollection) EnsureSkipListIndex(ctx context.Context, fields [
 
 func (m *MockArangoCollection) EnsureTTLIndex(ctx context.Context, field string, expireAfter int, options *arangoDriver.EnsureTTLIndexOptions) (arangoDriver.Index, bool, error) {
 	args := m.Called(ctx, field, expireAfter, options)
+	if args.Get(0) == nil {
+		return nil, args.Bool(1), args.Error(2)
+	}
+
 	return args.Get(0).(arangoDriver.Index), args.Bool(1), args.Error(2)
 }
 
@@ -427,3 +442,219 @@ func (m *MockArangoCollection) ImportDocuments(ctx context.Context, documents an
 	args := m.Called(ctx, documents, options)
 	return args.Get(0).(arangoDriver.ImportDocumentStatistics), args.Error(1)
 }
+
+func TestDecodeData(t *testing.T) {
+	data := []byte(`{"ID":"client-id"}`)
+
+	encoded, err := encodeData(data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		raw     json.RawMessage
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "decode base64 string",
+			raw:  encoded,
+			want: data,
+		},
+		{
+			name: "decode object",
+			raw:  json.RawMessage(data),
+			want: data,
+		},
+		{
+			name: "decode null",
+			raw:  json.RawMessage("null"),
+		},
+		{
+			name:    "decode invalid string",
+			raw:     json.RawMessage(`"not base64!"`),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := decodeData(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("decodeData() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		timeout      time.Duration
+		wantDeadline bool
+		wantSameCtx  bool
+	}{
+		{
+			name:         "context with timeout",
+			ctx:          context.Background(),
+			timeout:      time.Minute,
+			wantDeadline: true,
+		},
+		{
+			name:        "context without timeout",
+			ctx:         context.Background(),
+			wantSameCtx: true,
+		},
+		{
+			name:         "context with deadline",
+			ctx:          deadlineCtx,
+			timeout:      time.Minute,
+			wantDeadline: true,
+			wantSameCtx:  true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := withTimeout(tt.ctx, tt.timeout)
+			defer cancel()
+
+			if _, ok := ctx.Deadline(); ok != tt.wantDeadline {
+				t.Errorf("withTimeout() deadline = %v, want %v", ok, tt.wantDeadline)
+			}
+
+			if (ctx == tt.ctx) != tt.wantSameCtx {
+				t.Errorf("withTimeout() same context = %v, want %v", ctx == tt.ctx, tt.wantSameCtx)
+			}
+		})
+	}
+}
+
+var errCloseCursor = fmt.Errorf("close cursor")
+
+func TestWrapError(t *testing.T) {
+	cause := fmt.Errorf("error")
+	storeErr := &StoreError{Op: "RemoveByUser", Collection: "tokens", Cause: cause}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "wrap error",
+			err:  cause,
+			want: &StoreError{Op: "GetByAccess", Collection: "tokens", Cause: cause},
+		},
+		{
+			name: "wrap nil error",
+		},
+		{
+			name: "wrap store error",
+			err:  storeErr,
+			want: storeErr,
+		},
+		{
+			name: "wrap wrapped store error",
+			err:  fmt.Errorf("wrapped: %w", storeErr),
+			want: fmt.Errorf("wrapped: %w", storeErr),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.err
+			wrapError("GetByAccess", "tokens", &err)
+
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("wrapError() got = %v, want %v", err, tt.want)
+			}
+
+			if tt.err != nil && !errors.Is(err, cause) {
+				t.Errorf("wrapError() error = %v, wantErrIs %v", err, cause)
+			}
+		})
+	}
+}
+
+func TestStoreError_Error(t *testing.T) {
+	err := &StoreError{Op: "GetByAccess", Collection: "tokens", Cause: ErrTokenNotFound}
+
+	if want := "GetByAccess tokens: token not found"; err.Error() != want {
+		t.Errorf("Error() got = %v, want %v", err.Error(), want)
+	}
+
+	if !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Error() error = %v, wantErrIs %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestCloseCursor(t *testing.T) {
+	cause := fmt.Errorf("error")
+
+	tests := []struct {
+		name     string
+		err      error
+		closeErr error
+		wantIs   []error
+		wantNil  bool
+	}{
+		{
+			name:    "close cursor",
+			wantNil: true,
+		},
+		{
+			name:     "close cursor with error",
+			closeErr: errCloseCursor,
+			wantIs:   []error{errCloseCursor},
+		},
+		{
+			name:   "close cursor after error",
+			err:    cause,
+			wantIs: []error{cause},
+		},
+		{
+			name:     "close cursor with error after error",
+			err:      cause,
+			closeErr: errCloseCursor,
+			wantIs:   []error{cause},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cursor := new(MockArangoCursor)
+			cursor.On("Close").Return(tt.closeErr)
+
+			err := tt.err
+			closeCursor(cursor, &err)
+
+			if (err == nil) != tt.wantNil {
+				t.Fatalf("closeCursor() error = %v, wantNil %v", err, tt.wantNil)
+			}
+
+			for _, want := range tt.wantIs {
+				if !errors.Is(err, want) {
+					t.Errorf("closeCursor() error = %v, wantErrIs %v", err, want)
+				}
+			}
+
+			if tt.err != nil && tt.closeErr != nil && !strings.Contains(err.Error(), tt.closeErr.Error()) {
+				t.Errorf("closeCursor() error = %v, want it to mention %v", err, tt.closeErr)
+			}
+		})
+	}
+}
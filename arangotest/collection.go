@@ -0,0 +1,376 @@
+package arangotest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// document is a document as stored in a collection. Documents are never
+// modified once stored, so they can be shared with cursors safely.
+type document = map[string]any
+
+// Collection is an in-memory arangoDriver.Collection.
+type Collection struct {
+	arangoDriver.Collection
+
+	db      *Database
+	name    string
+	docs    map[string]document
+	indexes []*Index
+}
+
+// Name returns the name of the collection.
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// Database returns the database of the collection.
+func (c *Collection) Database() arangoDriver.Database {
+	return c.db
+}
+
+// Count returns the number of documents in the collection.
+func (c *Collection) Count(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	return int64(len(c.docs)), nil
+}
+
+// Truncate removes all documents from the collection.
+func (c *Collection) Truncate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transaction(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range c.keys() {
+		c.remove(tx, key)
+	}
+
+	return nil
+}
+
+// DocumentExists returns true if a document with the given key exists.
+func (c *Collection) DocumentExists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	_, ok := c.docs[key]
+
+	return ok, nil
+}
+
+// ReadDocument reads the document with the given key into result.
+func (c *Collection) ReadDocument(ctx context.Context, key string, result interface{}) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	doc, err := c.read(ctx, key)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	return documentMeta(doc), decodeValue(doc, result)
+}
+
+// CreateDocument creates a new document. A key is generated if the document
+// has none.
+func (c *Collection) CreateDocument(ctx context.Context, document interface{}) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	doc, err := toDocument(document)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transaction(ctx)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	doc, err = c.create(tx, doc)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	return documentMeta(doc), nil
+}
+
+// UpdateDocument merges the update into the document with the given key.
+func (c *Collection) UpdateDocument(ctx context.Context, key string, update interface{}) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	patch, err := toDocument(update)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transaction(ctx)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	old, err := c.read(ctx, key)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	doc := c.put(tx, key, merge(old, patch))
+
+	return documentMetaWithOld(doc, old), nil
+}
+
+// ReplaceDocument replaces the document with the given key.
+func (c *Collection) ReplaceDocument(ctx context.Context, key string, document interface{}) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	replacement, err := toDocument(document)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transaction(ctx)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	old, err := c.read(ctx, key)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	doc := c.put(tx, key, withoutSystemAttributes(replacement))
+
+	return documentMetaWithOld(doc, old), nil
+}
+
+// RemoveDocument removes the document with the given key.
+func (c *Collection) RemoveDocument(ctx context.Context, key string) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	tx, err := c.db.transaction(ctx)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	old, err := c.read(ctx, key)
+	if err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	c.remove(tx, key)
+
+	return documentMeta(old), nil
+}
+
+// read returns the document with the given key, checking the revision set
+// in the context if any.
+func (c *Collection) read(ctx context.Context, key string) (document, error) {
+	doc, ok := c.docs[key]
+	if !ok {
+		return nil, documentNotFound()
+	}
+
+	// The driver does not export its context keys, though their values are
+	// stable across versions.
+	if rev, ok := ctx.Value(arangoDriver.ContextKey("arangodb-revision")).(string); ok && rev != "" && rev != doc["_rev"] {
+		return nil, revisionMismatch()
+	}
+
+	return doc, nil
+}
+
+// create stores a new document, generating its key if it has none.
+func (c *Collection) create(tx *transaction, doc document) (document, error) {
+	key, ok := doc["_key"]
+	if !ok {
+		key = c.db.nextID()
+	}
+
+	k, ok := key.(string)
+	if !ok || k == "" || strings.ContainsAny(k, "/ ") {
+		return nil, newError(http.StatusBadRequest, errArangoDocumentKeyBad, "illegal document key")
+	}
+
+	if _, exists := c.docs[k]; exists {
+		return nil, uniqueConstraintViolated()
+	}
+
+	return c.put(tx, k, withoutSystemAttributes(doc)), nil
+}
+
+// put stores the document under the given key with a new revision, recording
+// the previous document in the transaction.
+func (c *Collection) put(tx *transaction, key string, doc document) document {
+	doc["_key"] = key
+	doc["_id"] = c.name + "/" + key
+	doc["_rev"] = c.db.nextID()
+
+	tx.record(c, key)
+	c.docs[key] = doc
+
+	return doc
+}
+
+// remove removes the document with the given key, recording it in the
+// transaction.
+func (c *Collection) remove(tx *transaction, key string) {
+	tx.record(c, key)
+	delete(c.docs, key)
+}
+
+// keys returns the keys of the documents in the collection in order.
+func (c *Collection) keys() []string {
+	keys := make([]string, 0, len(c.docs))
+	for key := range c.docs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// toDocument converts the value to a document the way the driver would
+// serialize it.
+func toDocument(v any) (document, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc document
+	if err := decodeJSON(data, &doc); err != nil || doc == nil {
+		return nil, newError(http.StatusBadRequest, errArangoDocumentTypeInvalid, "invalid document type")
+	}
+
+	return doc, nil
+}
+
+// decodeJSON decodes the data keeping numbers as json.Number, so they are not
+// altered when documents are written back.
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
+
+// decodeValue decodes the value into result like the driver decodes the
+// responses of ArangoDB.
+func decodeValue(v any, result any) error {
+	if result == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// withoutSystemAttributes returns a copy of the document without the
+// attributes managed by the database.
+func withoutSystemAttributes(doc document) document {
+	out := make(document, len(doc)+3)
+	for k, v := range doc {
+		switch k {
+		case "_key", "_id", "_rev":
+		default:
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
+// merge returns the document with the patch applied, merging nested objects
+// like ArangoDB does by default.
+func merge(doc document, patch document) document {
+	return mergeObjects(withoutSystemAttributes(doc), withoutSystemAttributes(patch))
+}
+
+func mergeObjects(obj map[string]any, patch map[string]any) map[string]any {
+	out := make(map[string]any, len(obj)+len(patch))
+	for k, v := range obj {
+		out[k] = v
+	}
+
+	for k, v := range patch {
+		old, oldOk := out[k].(map[string]any)
+		update, updateOk := v.(map[string]any)
+		if oldOk && updateOk {
+			v = mergeObjects(old, update)
+		}
+
+		out[k] = v
+	}
+
+	return out
+}
+
+func documentMeta(doc document) arangoDriver.DocumentMeta {
+	key, _ := doc["_key"].(string)
+	id, _ := doc["_id"].(string)
+	rev, _ := doc["_rev"].(string)
+
+	return arangoDriver.DocumentMeta{
+		Key: key,
+		ID:  arangoDriver.DocumentID(id),
+		Rev: rev,
+	}
+}
+
+func documentMetaWithOld(doc document, old document) arangoDriver.DocumentMeta {
+	meta := documentMeta(doc)
+	meta.OldRev, _ = old["_rev"].(string)
+
+	return meta
+}
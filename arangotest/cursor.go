@@ -0,0 +1,53 @@
+package arangotest
+
+import (
+	"context"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// Cursor is an arangoDriver.Cursor over the results of a query. All results
+// are computed when the query runs.
+type Cursor struct {
+	arangoDriver.Cursor
+
+	results []any
+	count   int64
+}
+
+// HasMore returns true if there are results left to read.
+func (c *Cursor) HasMore() bool {
+	return len(c.results) > 0
+}
+
+// ReadDocument reads the next result into result.
+func (c *Cursor) ReadDocument(ctx context.Context, result interface{}) (arangoDriver.DocumentMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return arangoDriver.DocumentMeta{}, err
+	}
+
+	if len(c.results) == 0 {
+		return arangoDriver.DocumentMeta{}, arangoDriver.NoMoreDocumentsError{}
+	}
+
+	v := c.results[0]
+	c.results = c.results[1:]
+
+	var meta arangoDriver.DocumentMeta
+	if doc, ok := v.(document); ok && doc["_key"] != nil {
+		meta = documentMeta(doc)
+	}
+
+	return meta, decodeValue(v, result)
+}
+
+// Count returns the total number of results.
+func (c *Cursor) Count() int64 {
+	return c.count
+}
+
+// Close closes the cursor.
+func (c *Cursor) Close() error {
+	c.results = nil
+	return nil
+}
@@ -0,0 +1,158 @@
+// Package arangotest provides an in-memory implementation of the ArangoDB
+// driver's Database, Collection and Cursor interfaces for testing.
+//
+// The fake stores the documents in memory and understands the subset of AQL
+// used by the stores of this module, so stores backed by it behave like they
+// do against a live ArangoDB. Methods of the driver interfaces that are not
+// implemented panic.
+//
+// Transactions are not isolated: their changes are visible to everyone right
+// away and are undone when the transaction is aborted.
+package arangotest
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// Database is an in-memory arangoDriver.Database.
+type Database struct {
+	arangoDriver.Database
+
+	name string
+
+	// mu guards the collections and the documents and indexes within them.
+	mu           sync.Mutex
+	collections  map[string]*Collection
+	transactions map[arangoDriver.TransactionID]*transaction
+	lastID       uint64
+}
+
+// NewDatabase creates a new, empty Database with the given name.
+func NewDatabase(name string) *Database {
+	return &Database{
+		name:         name,
+		collections:  make(map[string]*Collection),
+		transactions: make(map[arangoDriver.TransactionID]*transaction),
+	}
+}
+
+// nextID returns a new unique ID, used for document keys, revisions and
+// transaction IDs.
+func (db *Database) nextID() string {
+	db.lastID++
+	return strconv.FormatUint(db.lastID, 10)
+}
+
+// Name returns the name of the database.
+func (db *Database) Name() string {
+	return db.name
+}
+
+// Collection returns the collection with the given name.
+func (db *Database) Collection(ctx context.Context, name string) (arangoDriver.Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	coll, ok := db.collections[name]
+	if !ok {
+		return nil, collectionNotFound(name)
+	}
+
+	return coll, nil
+}
+
+// CollectionExists returns true if a collection with the given name exists.
+func (db *Database) CollectionExists(ctx context.Context, name string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, ok := db.collections[name]
+
+	return ok, nil
+}
+
+// Collections returns all collections of the database, ordered by name.
+func (db *Database) Collections(ctx context.Context) ([]arangoDriver.Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	names := make([]string, 0, len(db.collections))
+	for name := range db.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collections := make([]arangoDriver.Collection, 0, len(names))
+	for _, name := range names {
+		collections = append(collections, db.collections[name])
+	}
+
+	return collections, nil
+}
+
+// CreateCollection creates a new collection with the given name. The options
+// are ignored.
+func (db *Database) CreateCollection(ctx context.Context, name string, _ *arangoDriver.CreateCollectionOptions) (arangoDriver.Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.collections[name]; ok {
+		return nil, newError(http.StatusConflict, errArangoDuplicateName, "duplicate name: "+name)
+	}
+
+	coll := &Collection{
+		db:   db,
+		name: name,
+		docs: make(map[string]document),
+	}
+	db.collections[name] = coll
+
+	return coll, nil
+}
+
+// Query runs the AQL query using the given bind parameters. Only the subset
+// of AQL used by the stores is supported: a single FOR loop over a collection
+// followed by FILTER, LET, SORT, LIMIT, COLLECT WITH COUNT INTO, REMOVE,
+// UPDATE and RETURN operations.
+func (db *Database) Query(ctx context.Context, query string, bindVars map[string]interface{}) (arangoDriver.Cursor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	results, err := q.run(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cursor{results: results, count: int64(len(results))}, nil
+}
@@ -0,0 +1,175 @@
+package arangotest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+func TestCollection_Documents(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	coll, err := db.Collection(ctx, "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := coll.CreateDocument(ctx, map[string]any{"user_id": "user", "data": map[string]any{"a": 1, "b": 2}})
+	if err != nil {
+		t.Fatalf("CreateDocument() error = %v", err)
+	}
+
+	if _, err := coll.CreateDocument(ctx, map[string]any{"_key": meta.Key}); !arangoDriver.IsConflict(err) {
+		t.Errorf("CreateDocument() error = %v, want conflict", err)
+	}
+
+	updated, err := coll.UpdateDocument(ctx, meta.Key, map[string]any{"data": map[string]any{"b": 3}, "client_id": "client"})
+	if err != nil {
+		t.Fatalf("UpdateDocument() error = %v", err)
+	}
+
+	if _, err := coll.UpdateDocument(arangoDriver.WithRevision(ctx, meta.Rev), meta.Key, map[string]any{}); !arangoDriver.IsPreconditionFailed(err) {
+		t.Errorf("UpdateDocument() error = %v, want precondition failed", err)
+	}
+
+	var doc map[string]any
+	if _, err := coll.ReadDocument(ctx, meta.Key, &doc); err != nil {
+		t.Fatalf("ReadDocument() error = %v", err)
+	}
+
+	want := map[string]any{
+		"_key":      meta.Key,
+		"_id":       "tokens/" + meta.Key,
+		"_rev":      updated.Rev,
+		"user_id":   "user",
+		"client_id": "client",
+		"data":      map[string]any{"a": 1.0, "b": 3.0},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("ReadDocument() got = %v, want %v", doc, want)
+	}
+
+	if _, err := coll.ReplaceDocument(arangoDriver.WithRevision(ctx, updated.Rev), meta.Key, map[string]any{"user_id": "other"}); err != nil {
+		t.Fatalf("ReplaceDocument() error = %v", err)
+	}
+
+	doc = nil
+	if _, err := coll.ReadDocument(ctx, meta.Key, &doc); err != nil || doc["user_id"] != "other" || doc["client_id"] != nil {
+		t.Errorf("ReadDocument() got = %v, %v", doc, err)
+	}
+
+	if _, err := coll.RemoveDocument(ctx, meta.Key); err != nil {
+		t.Fatalf("RemoveDocument() error = %v", err)
+	}
+
+	if _, err := coll.ReadDocument(ctx, meta.Key, &doc); !arangoDriver.IsNotFoundGeneral(err) {
+		t.Errorf("ReadDocument() error = %v, want not found", err)
+	}
+
+	if _, err := coll.RemoveDocument(ctx, meta.Key); !arangoDriver.IsNotFoundGeneral(err) {
+		t.Errorf("RemoveDocument() error = %v, want not found", err)
+	}
+}
+
+func TestDatabase_Transaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		finish   func(ctx context.Context, db *Database, tid arangoDriver.TransactionID) error
+		wantKeys []string
+	}{
+		{
+			name: "commit transaction",
+			finish: func(ctx context.Context, db *Database, tid arangoDriver.TransactionID) error {
+				return db.CommitTransaction(ctx, tid, nil)
+			},
+			wantKeys: []string{"2", "3"},
+		},
+		{
+			name: "abort transaction",
+			finish: func(ctx context.Context, db *Database, tid arangoDriver.TransactionID) error {
+				return db.AbortTransaction(ctx, tid, nil)
+			},
+			wantKeys: []string{"1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := newTestDatabase(t, map[string]any{"_key": "1"}, map[string]any{"_key": "2", "code": "code"})
+
+			tid, err := db.BeginTransaction(ctx, arangoDriver.TransactionCollections{Write: []string{"tokens"}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txCtx := arangoDriver.WithTransactionID(ctx, tid)
+
+			coll, err := db.Collection(txCtx, "tokens")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := coll.CreateDocument(txCtx, map[string]any{"_key": "3"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := coll.UpdateDocument(txCtx, "2", map[string]any{"code": "other"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := db.Query(txCtx, "FOR doc IN tokens FILTER doc._key == '1' REMOVE doc IN tokens", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.finish(ctx, db, tid); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := documentKeys(t, db); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("documents = %v, want %v", got, tt.wantKeys)
+			}
+
+			if _, err := coll.CreateDocument(txCtx, map[string]any{}); !arangoDriver.IsNotFoundGeneral(err) {
+				t.Errorf("CreateDocument() error = %v, want not found", err)
+			}
+		})
+	}
+}
+
+func TestCollection_EnsureIndex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDatabase(t)
+
+	coll, err := db.Collection(ctx, "tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantCreated := range []bool{true, false} {
+		if _, created, err := coll.EnsurePersistentIndex(ctx, []string{"code"}, &arangoDriver.EnsurePersistentIndexOptions{Name: "idx_code"}); err != nil || created != wantCreated {
+			t.Errorf("EnsurePersistentIndex() #%d = %v, %v, want %v", i, created, err, wantCreated)
+		}
+
+		if _, created, err := coll.EnsureTTLIndex(ctx, "expires_at", 0, &arangoDriver.EnsureTTLIndexOptions{Name: "ttl_expires_at"}); err != nil || created != wantCreated {
+			t.Errorf("EnsureTTLIndex() #%d = %v, %v, want %v", i, created, err, wantCreated)
+		}
+	}
+
+	indexes, err := coll.Indexes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, index := range indexes {
+		names = append(names, index.Name())
+	}
+
+	if want := []string{"idx_code", "ttl_expires_at"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Indexes() got = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,47 @@
+package arangotest
+
+import (
+	"net/http"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// Error numbers returned by ArangoDB that have no constant in the driver.
+const (
+	errArangoDuplicateName       = 1207
+	errArangoDocumentKeyBad      = 1221
+	errArangoDocumentTypeInvalid = 1227
+	errQueryParse                = 1501
+	errQueryBindParameterMissing = 1551
+	errQueryFunctionNameUnknown  = 1540
+	errTransactionNotFound       = 1655
+)
+
+func newError(code int, errorNum int, message string) error {
+	return arangoDriver.ArangoError{
+		HasError:     true,
+		Code:         code,
+		ErrorNum:     errorNum,
+		ErrorMessage: message,
+	}
+}
+
+func collectionNotFound(name string) error {
+	return newError(http.StatusNotFound, arangoDriver.ErrArangoDataSourceNotFound, "collection or view not found: "+name)
+}
+
+func documentNotFound() error {
+	return newError(http.StatusNotFound, arangoDriver.ErrArangoDocumentNotFound, "document not found")
+}
+
+func revisionMismatch() error {
+	return newError(http.StatusPreconditionFailed, arangoDriver.ErrArangoConflict, "conflict, _rev values do not match")
+}
+
+func uniqueConstraintViolated() error {
+	return newError(http.StatusConflict, arangoDriver.ErrArangoUniqueConstraintViolated, "unique constraint violated")
+}
+
+func queryParseError(message string) error {
+	return newError(http.StatusBadRequest, errQueryParse, "syntax error: "+message)
+}
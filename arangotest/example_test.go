@@ -0,0 +1,42 @@
+package arangotest_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	arangostore "github.com/gabor-boros/go-oauth2-arangodb"
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+)
+
+func ExampleNewDatabase() {
+	ctx := context.Background()
+
+	tokenStore, err := arangostore.NewTokenStore(
+		arangostore.WithTokenStoreDatabase(arangotest.NewDatabase("oauth2")),
+		arangostore.WithTokenStoreEnsureSchema(nil),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	err = tokenStore.Create(ctx, &models.Token{
+		ClientID:        "client-id",
+		Access:          "access-token",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	info, err := tokenStore.GetByAccess(ctx, "access-token")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(info.GetClientID())
+	// Output: client-id
+}
@@ -0,0 +1,694 @@
+package arangotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenNumber
+	tokenString
+	tokenBind
+	tokenCollectionBind
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits the query into tokens.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+
+			tokens = append(tokens, token{kind: tokenWord, text: text[i:j]})
+			i = j
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(text) && (text[j] >= '0' && text[j] <= '9' || text[j] == '.') {
+				j++
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: text[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			s, n, err := readString(text[i:])
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: s})
+			i += n
+		case c == '@':
+			kind, j := tokenBind, i+1
+			if j < len(text) && text[j] == '@' {
+				kind, j = tokenCollectionBind, j+1
+			}
+
+			start := j
+			for j < len(text) && isWordChar(text[j]) {
+				j++
+			}
+
+			if j == start {
+				return nil, queryParseError("invalid bind parameter")
+			}
+
+			tokens = append(tokens, token{kind: kind, text: text[start:j]})
+			i = j
+		default:
+			if i+1 < len(text) {
+				switch op := text[i : i+2]; op {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, token{kind: tokenPunct, text: op})
+					i += 2
+
+					continue
+				}
+			}
+
+			if !strings.ContainsRune("<>!?:(){}[],.=-", rune(c)) {
+				return nil, queryParseError(fmt.Sprintf("unexpected character '%c'", c))
+			}
+
+			tokens = append(tokens, token{kind: tokenPunct, text: string(c)})
+			i++
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// readString reads a quoted string literal, returning its value and length.
+func readString(text string) (string, int, error) {
+	quote := text[0]
+
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		switch c := text[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(text) {
+				break
+			}
+
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", 0, queryParseError("unterminated string")
+}
+
+// expression evaluates to a value for the given row.
+type expression func(r row) any
+
+func constant(v any) expression {
+	return func(row) any { return v }
+}
+
+// parseExpression parses an expression. From the lowest to the highest, the
+// precedence of the operators is: ternary, OR, AND, comparison, unary.
+func (p *parser) parseExpression() (expression, error) {
+	condition, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.punct("?") {
+		return condition, nil
+	}
+
+	then, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectPunct(":"); err != nil {
+		return nil, err
+	}
+
+	otherwise, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r row) any {
+		if truthy(condition(r)) {
+			return then(r)
+		}
+
+		return otherwise(r)
+	}, nil
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") || p.punct("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(r row) any {
+			if v := l(r); truthy(v) {
+				return v
+			}
+
+			return right(r)
+		}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") || p.punct("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		left = func(r row) any {
+			if v := l(r); !truthy(v) {
+				return v
+			}
+
+			return right(r)
+		}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseComparison() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	if t.kind != tokenPunct {
+		return left, nil
+	}
+
+	var matches func(c int) bool
+	switch t.text {
+	case "==":
+		matches = func(c int) bool { return c == 0 }
+	case "!=":
+		matches = func(c int) bool { return c != 0 }
+	case "<":
+		matches = func(c int) bool { return c < 0 }
+	case "<=":
+		matches = func(c int) bool { return c <= 0 }
+	case ">":
+		matches = func(c int) bool { return c > 0 }
+	case ">=":
+		matches = func(c int) bool { return c >= 0 }
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r row) any {
+		return matches(compare(left(r), right(r)))
+	}, nil
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	switch {
+	case p.punct("!"), p.keyword("NOT"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(r row) any { return !truthy(operand(r)) }, nil
+	case p.punct("-"):
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(r row) any {
+			n, ok := toNumber(operand(r))
+			if !ok {
+				return nil
+			}
+
+			return -n
+		}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by attribute accesses.
+func (p *parser) parsePostfix() (expression, error) {
+	value, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.punct("."):
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+
+			value = attribute(value, constant(name))
+		case p.punct("["):
+			index, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expectPunct("]"); err != nil {
+				return nil, err
+			}
+
+			value = attribute(value, index)
+		default:
+			return value, nil
+		}
+	}
+}
+
+func attribute(value expression, name expression) expression {
+	return func(r row) any {
+		switch v := value(r).(type) {
+		case map[string]any:
+			if key, ok := name(r).(string); ok {
+				return v[key]
+			}
+		case []any:
+			if i, ok := toInt(name(r)); ok && i < len(v) {
+				return v[i]
+			}
+		}
+
+		return nil
+	}
+}
+
+func (p *parser) parsePrimary() (expression, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		if _, err := strconv.ParseFloat(t.text, 64); err != nil {
+			return nil, queryParseError("invalid number '" + t.text + "'")
+		}
+
+		return constant(json.Number(t.text)), nil
+	case tokenString:
+		return constant(t.text), nil
+	case tokenBind:
+		v, ok := p.bindVars[t.text]
+		if !ok {
+			return nil, bindParameterMissing(t.text)
+		}
+
+		return constant(v), nil
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			value, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+
+			return value, p.expectPunct(")")
+		case "{":
+			return p.parseObject()
+		case "[":
+			return p.parseArray()
+		}
+	case tokenWord:
+		switch strings.ToUpper(t.text) {
+		case "NULL":
+			return constant(nil), nil
+		case "TRUE":
+			return constant(true), nil
+		case "FALSE":
+			return constant(false), nil
+		}
+
+		if p.punct("(") {
+			return p.parseCall(t.text)
+		}
+
+		if !p.variables[t.text] {
+			return nil, newError(http.StatusBadRequest, errQueryParse, "variable '"+t.text+"' is not known")
+		}
+
+		name := t.text
+
+		return func(r row) any { return r[name] }, nil
+	}
+
+	return nil, queryParseError(fmt.Sprintf("unexpected '%s'", t.text))
+}
+
+func (p *parser) parseObject() (expression, error) {
+	var keys []string
+	var values []expression
+
+	for !p.punct("}") {
+		if len(keys) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+
+		t := p.next()
+		if t.kind != tokenWord && t.kind != tokenString {
+			return nil, queryParseError(fmt.Sprintf("expected attribute name near '%s'", t.text))
+		}
+
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, t.text)
+		values = append(values, value)
+	}
+
+	return func(r row) any {
+		obj := make(map[string]any, len(keys))
+		for i, key := range keys {
+			obj[key] = values[i](r)
+		}
+
+		return obj
+	}, nil
+}
+
+func (p *parser) parseArray() (expression, error) {
+	var values []expression
+
+	for !p.punct("]") {
+		if len(values) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return func(r row) any {
+		arr := make([]any, len(values))
+		for i, value := range values {
+			arr[i] = value(r)
+		}
+
+		return arr
+	}, nil
+}
+
+func (p *parser) parseCall(name string) (expression, error) {
+	var args []expression
+
+	for !p.punct(")") {
+		if len(args) > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	fn, ok := functions[strings.ToUpper(name)]
+	if !ok {
+		return nil, newError(http.StatusNotFound, errQueryFunctionNameUnknown, "usage of unknown function '"+name+"()'")
+	}
+
+	return func(r row) any {
+		values := make([]any, len(args))
+		for i, arg := range args {
+			values[i] = arg(r)
+		}
+
+		return fn(values)
+	}, nil
+}
+
+// functions are the supported AQL functions. Missing arguments are null.
+var functions = map[string]func(args []any) any{
+	"HAS": func(args []any) any {
+		obj, ok := arg(args, 0).(map[string]any)
+		if !ok {
+			return false
+		}
+
+		name, _ := arg(args, 1).(string)
+		_, ok = obj[name]
+
+		return ok
+	},
+	"NOT_NULL": func(args []any) any {
+		for _, v := range args {
+			if v != nil {
+				return v
+			}
+		}
+
+		return nil
+	},
+	"IS_NULL": func(args []any) any {
+		return arg(args, 0) == nil
+	},
+	"LENGTH": func(args []any) any {
+		switch v := arg(args, 0).(type) {
+		case nil:
+			return 0.0
+		case string:
+			return float64(len([]rune(v)))
+		case []any:
+			return float64(len(v))
+		case map[string]any:
+			return float64(len(v))
+		}
+
+		return 1.0
+	},
+	"DATE_TIMESTAMP": func(args []any) any {
+		switch v := arg(args, 0).(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil
+			}
+
+			return float64(t.UnixMilli())
+		case json.Number, float64:
+			n, _ := toNumber(v)
+			return n
+		}
+
+		return nil
+	},
+	"DATE_NOW": func([]any) any {
+		return float64(time.Now().UnixMilli())
+	},
+}
+
+func arg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+
+	return nil
+}
+
+// truthy converts the value to a boolean like AQL does.
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case json.Number, float64:
+		n, _ := toNumber(v)
+		return n != 0
+	}
+
+	return true
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	}
+
+	return 0, false
+}
+
+func toInt(v any) (int, bool) {
+	n, ok := toNumber(v)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return 0, false
+	}
+
+	return int(n), true
+}
+
+// typeOrder returns the rank of the type of the value in the AQL sort order:
+// null < bool < number < string < array < object.
+func typeOrder(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case json.Number, float64:
+		return 2
+	case string:
+		return 3
+	case []any:
+		return 4
+	}
+
+	return 5
+}
+
+// compare compares two values like AQL does, returning -1, 0 or 1.
+func compare(a any, b any) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return sign(ta - tb)
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case a:
+			return 1
+		}
+
+		return -1
+	case json.Number, float64:
+		x, _ := toNumber(a)
+		y, _ := toNumber(b)
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []any:
+		y := b.([]any)
+		for i := 0; i < len(a) && i < len(y); i++ {
+			if c := compare(a[i], y[i]); c != 0 {
+				return c
+			}
+		}
+
+		return sign(len(a) - len(y))
+	}
+
+	// Objects are compared by their serialized form, which orders the
+	// attributes by name.
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+
+	return strings.Compare(string(x), string(y))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
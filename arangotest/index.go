@@ -0,0 +1,139 @@
+package arangotest
+
+import (
+	"context"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// Index is an index of a Collection. Indexes are recorded, but do not affect
+// the behavior of the collection.
+type Index struct {
+	arangoDriver.Index
+
+	id          string
+	name        string
+	indexType   arangoDriver.IndexType
+	fields      []string
+	expireAfter int
+}
+
+// ID returns the ID of the index.
+func (i *Index) ID() string {
+	return i.id
+}
+
+// Name returns the name of the index.
+func (i *Index) Name() string {
+	return i.name
+}
+
+// UserName returns the name of the index.
+func (i *Index) UserName() string {
+	return i.name
+}
+
+// Type returns the type of the index.
+func (i *Index) Type() arangoDriver.IndexType {
+	return i.indexType
+}
+
+// Fields returns the fields covered by the index.
+func (i *Index) Fields() []string {
+	return i.fields
+}
+
+// ExpireAfter returns the number of seconds after which documents expire,
+// for TTL indexes.
+func (i *Index) ExpireAfter() int {
+	return i.expireAfter
+}
+
+// Indexes returns the indexes of the collection.
+func (c *Collection) Indexes(ctx context.Context) ([]arangoDriver.Index, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	indexes := make([]arangoDriver.Index, 0, len(c.indexes))
+	for _, index := range c.indexes {
+		indexes = append(indexes, index)
+	}
+
+	return indexes, nil
+}
+
+// EnsurePersistentIndex creates a persistent index on the fields, unless it
+// exists already.
+func (c *Collection) EnsurePersistentIndex(ctx context.Context, fields []string, options *arangoDriver.EnsurePersistentIndexOptions) (arangoDriver.Index, bool, error) {
+	var name string
+	if options != nil {
+		name = options.Name
+	}
+
+	return c.ensureIndex(ctx, &Index{
+		name:      name,
+		indexType: arangoDriver.PersistentIndex,
+		fields:    fields,
+	})
+}
+
+// EnsureTTLIndex creates a TTL index on the field, unless it exists already.
+func (c *Collection) EnsureTTLIndex(ctx context.Context, field string, expireAfter int, options *arangoDriver.EnsureTTLIndexOptions) (arangoDriver.Index, bool, error) {
+	var name string
+	if options != nil {
+		name = options.Name
+	}
+
+	return c.ensureIndex(ctx, &Index{
+		name:        name,
+		indexType:   arangoDriver.TTLIndex,
+		fields:      []string{field},
+		expireAfter: expireAfter,
+	})
+}
+
+// ensureIndex returns the index of the same type on the same fields if it
+// exists, otherwise it creates the given index.
+func (c *Collection) ensureIndex(ctx context.Context, index *Index) (arangoDriver.Index, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, existing := range c.indexes {
+		if existing.indexType == index.indexType && equalFields(existing.fields, index.fields) {
+			return existing, false, nil
+		}
+	}
+
+	id := c.db.nextID()
+	if index.name == "" {
+		index.name = "idx_" + id
+	}
+	index.id = c.name + "/" + id
+	index.fields = append([]string(nil), index.fields...)
+
+	c.indexes = append(c.indexes, index)
+
+	return index, true, nil
+}
+
+func equalFields(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
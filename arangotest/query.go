@@ -0,0 +1,576 @@
+package arangotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// query is a parsed AQL query of the form
+//
+//	FOR <variable> IN <collection> <operation>...
+type query struct {
+	variable   string
+	collection string
+	operations []operation
+}
+
+// row holds the variables of one iteration of the loop.
+type row map[string]any
+
+// operation is a high-level operation of a query, applied to all rows.
+type operation interface {
+	apply(ctx context.Context, db *Database, rows []row) ([]row, error)
+}
+
+// run runs the query, returning its results. The database must be locked.
+func (q *query) run(ctx context.Context, db *Database) ([]any, error) {
+	coll, ok := db.collections[q.collection]
+	if !ok {
+		return nil, collectionNotFound(q.collection)
+	}
+
+	rows := make([]row, 0, len(coll.docs))
+	for _, key := range coll.keys() {
+		rows = append(rows, row{q.variable: coll.docs[key]})
+	}
+
+	var results []any
+	for _, op := range q.operations {
+		if ret, ok := op.(*returnOperation); ok {
+			for _, r := range rows {
+				results = append(results, ret.value(r))
+			}
+
+			return results, nil
+		}
+
+		var err error
+		if rows, err = op.apply(ctx, db, rows); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// filterOperation keeps the rows the condition is true for.
+type filterOperation struct {
+	condition expression
+}
+
+func (op *filterOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	filtered := rows[:0]
+	for _, r := range rows {
+		if truthy(op.condition(r)) {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered, nil
+}
+
+// letOperation assigns a value to a variable.
+type letOperation struct {
+	variable string
+	value    expression
+}
+
+func (op *letOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	for _, r := range rows {
+		r[op.variable] = op.value(r)
+	}
+
+	return rows, nil
+}
+
+// sortOperation sorts the rows.
+type sortOperation struct {
+	keys       []expression
+	descending []bool
+}
+
+func (op *sortOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, key := range op.keys {
+			c := compare(key(rows[i]), key(rows[j]))
+			if op.descending[k] {
+				c = -c
+			}
+
+			if c != 0 {
+				return c < 0
+			}
+		}
+
+		return false
+	})
+
+	return rows, nil
+}
+
+// limitOperation skips offset rows and keeps at most count rows.
+type limitOperation struct {
+	offset expression
+	count  expression
+}
+
+func (op *limitOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	offset, ok := toInt(op.offset(nil))
+	if !ok {
+		return nil, newError(http.StatusBadRequest, errQueryParse, "invalid LIMIT offset")
+	}
+
+	count, ok := toInt(op.count(nil))
+	if !ok {
+		return nil, newError(http.StatusBadRequest, errQueryParse, "invalid LIMIT count")
+	}
+
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+
+	if count < len(rows) {
+		rows = rows[:count]
+	}
+
+	return rows, nil
+}
+
+// countOperation replaces the rows with a single row holding their number.
+type countOperation struct {
+	variable string
+}
+
+func (op *countOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	return []row{{op.variable: json.Number(fmt.Sprint(len(rows)))}}, nil
+}
+
+// removeOperation removes the documents from the collection, making them
+// available as OLD.
+type removeOperation struct {
+	document   expression
+	collection string
+}
+
+func (op *removeOperation) apply(ctx context.Context, db *Database, rows []row) ([]row, error) {
+	coll, tx, err := writeCollection(ctx, db, op.collection)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		key, err := documentKey(op.document(r))
+		if err != nil {
+			return nil, err
+		}
+
+		old, ok := coll.docs[key]
+		if !ok {
+			return nil, documentNotFound()
+		}
+
+		coll.remove(tx, key)
+		r["OLD"] = old
+	}
+
+	return rows, nil
+}
+
+// updateOperation merges the changes into the documents, making the previous
+// documents available as OLD and the updated ones as NEW.
+type updateOperation struct {
+	document   expression
+	changes    expression
+	collection string
+}
+
+func (op *updateOperation) apply(ctx context.Context, db *Database, rows []row) ([]row, error) {
+	coll, tx, err := writeCollection(ctx, db, op.collection)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rows {
+		key, err := documentKey(op.document(r))
+		if err != nil {
+			return nil, err
+		}
+
+		old, ok := coll.docs[key]
+		if !ok {
+			return nil, documentNotFound()
+		}
+
+		changes, ok := op.changes(r).(map[string]any)
+		if !ok {
+			return nil, newError(http.StatusBadRequest, errArangoDocumentTypeInvalid, "invalid document type")
+		}
+
+		r["OLD"] = old
+		r["NEW"] = coll.put(tx, key, merge(old, changes))
+	}
+
+	return rows, nil
+}
+
+// returnOperation returns a value for every row. It is always the last
+// operation of a query.
+type returnOperation struct {
+	value expression
+}
+
+func (op *returnOperation) apply(_ context.Context, _ *Database, rows []row) ([]row, error) {
+	return rows, nil
+}
+
+func writeCollection(ctx context.Context, db *Database, name string) (*Collection, *transaction, error) {
+	coll, ok := db.collections[name]
+	if !ok {
+		return nil, nil, collectionNotFound(name)
+	}
+
+	tx, err := db.transaction(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return coll, tx, nil
+}
+
+// documentKey returns the key of a document given as document or key.
+func documentKey(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case map[string]any:
+		if key, ok := v["_key"].(string); ok {
+			return key, nil
+		}
+	}
+
+	return "", newError(http.StatusBadRequest, errArangoDocumentKeyBad, "illegal document key")
+}
+
+// parser parses a query into operations. Bind parameters are resolved while
+// parsing.
+type parser struct {
+	tokens    []token
+	pos       int
+	bindVars  map[string]any
+	variables map[string]bool
+}
+
+func parseQuery(text string, bindVars map[string]any) (*query, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := normalizeBindVars(bindVars)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, bindVars: vars, variables: make(map[string]bool)}
+
+	return p.parseQuery()
+}
+
+// normalizeBindVars converts the bind parameters the way the driver would
+// serialize them.
+func normalizeBindVars(bindVars map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(bindVars)
+	if err != nil {
+		return nil, err
+	}
+
+	var vars map[string]any
+	if err := decodeJSON(data, &vars); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *parser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// punct consumes the next token if it is the given punctuation.
+func (p *parser) punct(text string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == text {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		return queryParseError(fmt.Sprintf("expected %s near '%s'", word, p.peek().text))
+	}
+
+	return nil
+}
+
+func (p *parser) expectPunct(text string) error {
+	if !p.punct(text) {
+		return queryParseError(fmt.Sprintf("expected '%s' near '%s'", text, p.peek().text))
+	}
+
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return "", queryParseError(fmt.Sprintf("expected identifier near '%s'", t.text))
+	}
+
+	return t.text, nil
+}
+
+// collection parses a collection given by name or bind parameter.
+func (p *parser) collection() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenWord:
+		return t.text, nil
+	case tokenCollectionBind:
+		name, ok := p.bindVars["@"+t.text].(string)
+		if !ok {
+			return "", bindParameterMissing("@" + t.text)
+		}
+
+		return name, nil
+	}
+
+	return "", queryParseError(fmt.Sprintf("expected collection near '%s'", t.text))
+}
+
+func (p *parser) parseQuery() (*query, error) {
+	if err := p.expectKeyword("FOR"); err != nil {
+		return nil, err
+	}
+
+	variable, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	p.variables[variable] = true
+
+	if err := p.expectKeyword("IN"); err != nil {
+		return nil, err
+	}
+
+	collection, err := p.collection()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &query{variable: variable, collection: collection}
+
+	for p.peek().kind != tokenEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+
+		q.operations = append(q.operations, op)
+
+		if _, ok := op.(*returnOperation); ok && p.peek().kind != tokenEOF {
+			return nil, queryParseError(fmt.Sprintf("unexpected '%s' after RETURN", p.peek().text))
+		}
+	}
+
+	return q, nil
+}
+
+func (p *parser) parseOperation() (operation, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		return nil, queryParseError(fmt.Sprintf("unexpected '%s'", t.text))
+	}
+
+	switch strings.ToUpper(t.text) {
+	case "FILTER":
+		condition, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		return &filterOperation{condition: condition}, nil
+	case "LET":
+		variable, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectPunct("="); err != nil {
+			return nil, err
+		}
+
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		p.variables[variable] = true
+
+		return &letOperation{variable: variable, value: value}, nil
+	case "SORT":
+		return p.parseSort()
+	case "LIMIT":
+		return p.parseLimit()
+	case "COLLECT":
+		return p.parseCollect()
+	case "REMOVE":
+		return p.parseRemove()
+	case "UPDATE":
+		return p.parseUpdate()
+	case "RETURN":
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		return &returnOperation{value: value}, nil
+	}
+
+	return nil, queryParseError(fmt.Sprintf("unsupported operation '%s'", t.text))
+}
+
+func (p *parser) parseSort() (operation, error) {
+	op := new(sortOperation)
+
+	for {
+		key, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		descending := p.keyword("DESC")
+		if !descending {
+			p.keyword("ASC")
+		}
+
+		op.keys = append(op.keys, key)
+		op.descending = append(op.descending, descending)
+
+		if !p.punct(",") {
+			return op, nil
+		}
+	}
+}
+
+func (p *parser) parseLimit() (operation, error) {
+	first, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.punct(",") {
+		return &limitOperation{offset: constant(json.Number("0")), count: first}, nil
+	}
+
+	count, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitOperation{offset: first, count: count}, nil
+}
+
+func (p *parser) parseCollect() (operation, error) {
+	for _, word := range []string{"WITH", "COUNT", "INTO"} {
+		if err := p.expectKeyword(word); err != nil {
+			return nil, err
+		}
+	}
+
+	variable, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+
+	// The variables of the loop are not available after COLLECT.
+	p.variables = map[string]bool{variable: true}
+
+	return &countOperation{variable: variable}, nil
+}
+
+func (p *parser) parseRemove() (operation, error) {
+	doc, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("IN"); err != nil {
+		return nil, err
+	}
+
+	collection, err := p.collection()
+	if err != nil {
+		return nil, err
+	}
+	p.variables["OLD"] = true
+
+	return &removeOperation{document: doc, collection: collection}, nil
+}
+
+func (p *parser) parseUpdate() (operation, error) {
+	doc, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("WITH"); err != nil {
+		return nil, err
+	}
+
+	changes, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("IN"); err != nil {
+		return nil, err
+	}
+
+	collection, err := p.collection()
+	if err != nil {
+		return nil, err
+	}
+	p.variables["OLD"] = true
+	p.variables["NEW"] = true
+
+	return &updateOperation{document: doc, changes: changes, collection: collection}, nil
+}
+
+func bindParameterMissing(name string) error {
+	return newError(http.StatusBadRequest, errQueryBindParameterMissing, "no value specified for declared bind parameter '"+name+"'")
+}
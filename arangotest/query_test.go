@@ -0,0 +1,228 @@
+package arangotest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+func newTestDatabase(t *testing.T, docs ...map[string]any) *Database {
+	t.Helper()
+	ctx := context.Background()
+
+	db := NewDatabase("test")
+
+	coll, err := db.CreateCollection(ctx, "tokens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range docs {
+		if _, err := coll.CreateDocument(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// readAll reads the given attribute of every result of the cursor, or the
+// results themselves if attr is empty.
+func readAll(t *testing.T, cursor arangoDriver.Cursor, attr string) []any {
+	t.Helper()
+	ctx := context.Background()
+
+	var results []any
+	for cursor.HasMore() {
+		var v any
+		if _, err := cursor.ReadDocument(ctx, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		if attr != "" {
+			v = v.(map[string]any)[attr]
+		}
+
+		results = append(results, v)
+	}
+
+	if _, err := cursor.ReadDocument(ctx, nil); !arangoDriver.IsNoMoreDocuments(err) {
+		t.Errorf("ReadDocument() error = %v, want no more documents", err)
+	}
+
+	return results
+}
+
+func TestDatabase_Query(t *testing.T) {
+	docs := []map[string]any{
+		{"_key": "1", "user_id": "user", "code": "", "expires_at": "2023-06-05T10:00:00Z"},
+		{"_key": "2", "user_id": "user", "code": "code", "expires_at": nil},
+		{"_key": "3", "user_id": "other", "code": "", "expires_at": "2023-06-05T12:00:00Z"},
+		{"_key": "4", "family_id": "family"},
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		bindVars map[string]any
+		attr     string
+		want     []any
+		wantKeys []string
+		wantErr  int
+	}{
+		{
+			name:     "filter by attribute",
+			query:    "FOR doc IN @@collection FILTER doc.user_id == @user_id RETURN doc",
+			bindVars: map[string]any{"@collection": "tokens", "user_id": "user"},
+			attr:     "_key",
+			want:     []any{"1", "2"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "filter by collection name",
+			query:    "FOR doc IN tokens FILTER doc.user_id != 'user' RETURN doc._key",
+			want:     []any{"3", "4"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "filter by missing attribute",
+			query:    "FOR doc IN @@collection FILTER !HAS(doc, 'user_id') RETURN doc",
+			bindVars: map[string]any{"@collection": "tokens"},
+			attr:     "_key",
+			want:     []any{"4"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name: "filter by date",
+			query: "FOR doc IN @@collection LET expires_at = HAS(doc, 'expires_at') ? doc.expires_at : doc.missing" +
+				" FILTER expires_at == null OR DATE_TIMESTAMP(expires_at) > @now RETURN doc._key",
+			bindVars: map[string]any{"@collection": "tokens", "now": int64(1685962800000)},
+			want:     []any{"2", "3", "4"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "sort and limit",
+			query:    "FOR doc IN @@collection FILTER doc._key > @cursor SORT doc._key DESC LIMIT @limit RETURN doc._key",
+			bindVars: map[string]any{"@collection": "tokens", "cursor": "1", "limit": 2},
+			want:     []any{"4", "3"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "limit with offset",
+			query:    "FOR doc IN @@collection SORT doc._key LIMIT 1, 2 RETURN doc._key",
+			bindVars: map[string]any{"@collection": "tokens"},
+			want:     []any{"2", "3"},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "count",
+			query:    "FOR doc IN @@collection FILTER doc.user_id == @user_id COLLECT WITH COUNT INTO count RETURN count",
+			bindVars: map[string]any{"@collection": "tokens", "user_id": "user"},
+			want:     []any{2.0},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "remove",
+			query:    "FOR doc IN @@collection FILTER doc.code == '' REMOVE doc IN @@collection",
+			bindVars: map[string]any{"@collection": "tokens"},
+			wantKeys: []string{"2", "4"},
+		},
+		{
+			name:     "remove and return old",
+			query:    "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection RETURN OLD",
+			bindVars: map[string]any{"@collection": "tokens", "code": "code"},
+			attr:     "_key",
+			want:     []any{"2"},
+			wantKeys: []string{"1", "3", "4"},
+		},
+		{
+			name: "update and return old",
+			query: "FOR doc IN @@collection FILTER doc._key == '1'" +
+				" UPDATE doc WITH { code: NOT_NULL(doc.code, @code), consumed: true } IN @@collection RETURN OLD",
+			bindVars: map[string]any{"@collection": "tokens", "code": "new-code"},
+			attr:     "code",
+			want:     []any{""},
+			wantKeys: []string{"1", "2", "3", "4"},
+		},
+		{
+			name:     "query unknown collection",
+			query:    "FOR doc IN @@collection RETURN doc",
+			bindVars: map[string]any{"@collection": "unknown"},
+			wantErr:  arangoDriver.ErrArangoDataSourceNotFound,
+		},
+		{
+			name:    "query with missing bind parameter",
+			query:   "FOR doc IN tokens FILTER doc.user_id == @user_id RETURN doc",
+			wantErr: errQueryBindParameterMissing,
+		},
+		{
+			name:    "query with unknown variable",
+			query:   "FOR doc IN tokens FILTER token.user_id == 'user' RETURN doc",
+			wantErr: errQueryParse,
+		},
+		{
+			name:    "query with unknown function",
+			query:   "FOR doc IN tokens FILTER UNKNOWN(doc) RETURN doc",
+			wantErr: errQueryFunctionNameUnknown,
+		},
+		{
+			name:    "query with invalid syntax",
+			query:   "FOR doc IN tokens FILTER doc.user_id == RETURN doc",
+			wantErr: errQueryParse,
+		},
+		{
+			name:    "query with operation after return",
+			query:   "FOR doc IN tokens RETURN doc FILTER doc.user_id == 'user'",
+			wantErr: errQueryParse,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := newTestDatabase(t, docs...)
+
+			cursor, err := db.Query(ctx, tt.query, tt.bindVars)
+			if tt.wantErr != 0 {
+				var arangoErr arangoDriver.ArangoError
+				if !errors.As(err, &arangoErr) || arangoErr.ErrorNum != tt.wantErr {
+					t.Fatalf("Query() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+
+			if got := readAll(t, cursor, tt.attr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() got = %v, want %v", got, tt.want)
+			}
+
+			if got := documentKeys(t, db); !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("Query() documents = %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func documentKeys(t *testing.T, db *Database) []string {
+	t.Helper()
+
+	cursor, err := db.Query(context.Background(), "FOR doc IN tokens RETURN doc._key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, key := range readAll(t, cursor, "") {
+		keys = append(keys, key.(string))
+	}
+
+	return keys
+}
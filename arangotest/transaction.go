@@ -0,0 +1,115 @@
+package arangotest
+
+import (
+	"context"
+	"net/http"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// transaction records the previous state of the documents written within a
+// stream transaction, so they can be restored when it is aborted.
+type transaction struct {
+	undo []func()
+}
+
+// record records the current state of the document with the given key. A nil
+// transaction records nothing.
+func (tx *transaction) record(c *Collection, key string) {
+	if tx == nil {
+		return
+	}
+
+	doc, ok := c.docs[key]
+	tx.undo = append(tx.undo, func() {
+		if ok {
+			c.docs[key] = doc
+		} else {
+			delete(c.docs, key)
+		}
+	})
+}
+
+// transaction returns the transaction the context belongs to, or nil if it
+// does not belong to any.
+func (db *Database) transaction(ctx context.Context) (*transaction, error) {
+	tid, ok := ctx.Value(arangoDriver.ContextKey("arangodb-transactionID")).(arangoDriver.TransactionID)
+	if !ok || tid == "" {
+		return nil, nil
+	}
+
+	tx, ok := db.transactions[tid]
+	if !ok {
+		return nil, transactionNotFound(tid)
+	}
+
+	return tx, nil
+}
+
+// BeginTransaction begins a new stream transaction. The options are ignored.
+func (db *Database) BeginTransaction(ctx context.Context, cols arangoDriver.TransactionCollections, _ *arangoDriver.BeginTransactionOptions) (arangoDriver.TransactionID, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, names := range [][]string{cols.Read, cols.Write, cols.Exclusive} {
+		for _, name := range names {
+			if _, ok := db.collections[name]; !ok {
+				return "", collectionNotFound(name)
+			}
+		}
+	}
+
+	tid := arangoDriver.TransactionID(db.nextID())
+	db.transactions[tid] = new(transaction)
+
+	return tid, nil
+}
+
+// CommitTransaction commits the stream transaction.
+func (db *Database) CommitTransaction(ctx context.Context, tid arangoDriver.TransactionID, _ *arangoDriver.CommitTransactionOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.transactions[tid]; !ok {
+		return transactionNotFound(tid)
+	}
+
+	delete(db.transactions, tid)
+
+	return nil
+}
+
+// AbortTransaction aborts the stream transaction, undoing its changes.
+func (db *Database) AbortTransaction(ctx context.Context, tid arangoDriver.TransactionID, _ *arangoDriver.AbortTransactionOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx, ok := db.transactions[tid]
+	if !ok {
+		return transactionNotFound(tid)
+	}
+
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+
+	delete(db.transactions, tid)
+
+	return nil
+}
+
+func transactionNotFound(tid arangoDriver.TransactionID) error {
+	return newError(http.StatusNotFound, errTransactionNotFound, "transaction '"+string(tid)+"' not found")
+}
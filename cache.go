@@ -0,0 +1,221 @@
+package arangostore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cacheEntry is an entry of the lruCache. Entries that are not found record
+// that the key does not exist.
+type cacheEntry[V any] struct {
+	key       string
+	aliases   []string
+	partial   bool
+	value     V
+	found     bool
+	expiresAt time.Time
+}
+
+// lruCache is a size-bounded cache evicting the least recently used entries.
+// Entries expire after the TTL, or the negative TTL for keys that were not
+// found.
+type lruCache[V any] struct {
+	mu          sync.Mutex
+	maxSize     int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	items       map[string]*list.Element
+	aliases     map[string]string
+	partial     int
+	order       *list.List
+	generation  uint64
+	now         func() time.Time
+}
+
+// newLRUCache creates a new lruCache. A zero negativeTTL disables caching of
+// keys that were not found.
+func newLRUCache[V any](maxSize int, ttl time.Duration, negativeTTL time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		maxSize:     maxSize,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		items:       make(map[string]*list.Element, maxSize),
+		aliases:     make(map[string]string),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// get returns the cached value of the key. The second value reports whether
+// the key was found when it was cached, the third whether the key is cached at
+// all.
+func (c *lruCache[V]) get(key string) (V, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false, false
+	}
+
+	entry := elem.Value.(*cacheEntry[V])
+	if !c.now().Before(entry.expiresAt) {
+		c.removeElement(elem)
+		return zero, false, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return entry.value, entry.found, true
+}
+
+// snapshot returns the current generation of the cache, which is passed to
+// set to make sure no invalidation happened since the value was read.
+func (c *lruCache[V]) snapshot() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// set caches the value of the key, unless the cache was invalidated since the
+// given generation.
+func (c *lruCache[V]) set(key string, value V, generation uint64) {
+	c.put(&cacheEntry[V]{key: key, value: value, found: true}, c.ttl, generation)
+}
+
+// setFor caches the value of the key for the given duration, capped by the
+// TTL of the cache. The value can be invalidated by any of the aliases too. If
+// partial is set, not every alias of the value is known, hence the value is
+// invalidated by any alias that is not known either.
+func (c *lruCache[V]) setFor(key string, value V, ttl time.Duration, generation uint64, partial bool, aliases ...string) {
+	if ttl > c.ttl {
+		ttl = c.ttl
+	}
+
+	c.put(&cacheEntry[V]{key: key, aliases: aliases, partial: partial, value: value, found: true}, ttl, generation)
+}
+
+// setMissing caches that the key does not exist, unless the cache was
+// invalidated since the given generation.
+func (c *lruCache[V]) setMissing(key string, generation uint64) {
+	if c.negativeTTL == 0 {
+		return
+	}
+
+	c.put(&cacheEntry[V]{key: key}, c.negativeTTL, generation)
+}
+
+func (c *lruCache[V]) put(entry *cacheEntry[V], ttl time.Duration, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The value may be stale if the cache was invalidated in the meantime.
+	if generation != c.generation {
+		return
+	}
+
+	entry.expiresAt = c.now().Add(ttl)
+
+	if elem, ok := c.items[entry.key]; ok {
+		c.removeElement(elem)
+	}
+
+	c.items[entry.key] = c.order.PushFront(entry)
+	for _, alias := range entry.aliases {
+		c.aliases[alias] = entry.key
+	}
+
+	if entry.partial {
+		c.partial++
+	}
+
+	for c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove invalidates the cached value of the key.
+func (c *lruCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeAlias invalidates the cached value the alias belongs to. If the alias
+// is not known, the values whose aliases are not all known are invalidated, as
+// the alias may belong to any of them.
+func (c *lruCache[V]) removeAlias(alias string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if elem, ok := c.items[c.aliases[alias]]; ok {
+		c.removeElement(elem)
+		return
+	}
+
+	for elem := c.order.Front(); elem != nil && c.partial > 0; {
+		next := elem.Next()
+		if elem.Value.(*cacheEntry[V]).partial {
+			c.removeElement(elem)
+		}
+
+		elem = next
+	}
+}
+
+// removeFunc invalidates the cached values matching the predicate.
+func (c *lruCache[V]) removeFunc(match func(value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*cacheEntry[V]); entry.found && match(entry.value) {
+			c.removeElement(elem)
+		}
+
+		elem = next
+	}
+}
+
+// clear invalidates every cached value.
+func (c *lruCache[V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.items = make(map[string]*list.Element, c.maxSize)
+	c.aliases = make(map[string]string)
+	c.partial = 0
+	c.order.Init()
+}
+
+func (c *lruCache[V]) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry[V])
+
+	c.order.Remove(elem)
+	delete(c.items, entry.key)
+
+	if entry.partial {
+		c.partial--
+	}
+
+	for _, alias := range entry.aliases {
+		if c.aliases[alias] == entry.key {
+			delete(c.aliases, alias)
+		}
+	}
+}
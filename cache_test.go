@@ -0,0 +1,174 @@
+package arangostore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	type lookup struct {
+		key       string
+		want      string
+		wantFound bool
+		wantOk    bool
+	}
+	tests := []struct {
+		name    string
+		prepare func(c *lruCache[string], clock *time.Time)
+		lookups []lookup
+	}{
+		{
+			name: "get cached value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.set("a", "value-a", c.snapshot())
+			},
+			lookups: []lookup{
+				{key: "a", want: "value-a", wantFound: true, wantOk: true},
+				{key: "b"},
+			},
+		},
+		{
+			name: "get expired value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.set("a", "value-a", c.snapshot())
+				*clock = clock.Add(time.Minute)
+			},
+			lookups: []lookup{
+				{key: "a"},
+			},
+		},
+		{
+			name: "get missing key",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.setMissing("a", c.snapshot())
+			},
+			lookups: []lookup{
+				{key: "a", wantOk: true},
+			},
+		},
+		{
+			name: "get expired missing key",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.setMissing("a", c.snapshot())
+				*clock = clock.Add(time.Second)
+			},
+			lookups: []lookup{
+				{key: "a"},
+			},
+		},
+		{
+			name: "evict least recently used value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.set("a", "value-a", c.snapshot())
+				c.set("b", "value-b", c.snapshot())
+				c.get("a")
+				c.set("c", "value-c", c.snapshot())
+			},
+			lookups: []lookup{
+				{key: "a", want: "value-a", wantFound: true, wantOk: true},
+				{key: "b"},
+				{key: "c", want: "value-c", wantFound: true, wantOk: true},
+			},
+		},
+		{
+			name: "remove value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.set("a", "value-a", c.snapshot())
+				c.remove("a")
+			},
+			lookups: []lookup{
+				{key: "a"},
+			},
+		},
+		{
+			name: "remove unknown alias of partial value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.setFor("a", "value-a", time.Minute, c.snapshot(), true)
+				c.setFor("b", "value-b", time.Minute, c.snapshot(), false, "alias-b")
+				c.removeAlias("alias-a")
+			},
+			lookups: []lookup{
+				{key: "a"},
+				{key: "b", want: "value-b", wantFound: true, wantOk: true},
+			},
+		},
+		{
+			name: "remove known alias keeping partial value",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.setFor("a", "value-a", time.Minute, c.snapshot(), true)
+				c.setFor("b", "value-b", time.Minute, c.snapshot(), false, "alias-b")
+				c.removeAlias("alias-b")
+			},
+			lookups: []lookup{
+				{key: "a", want: "value-a", wantFound: true, wantOk: true},
+				{key: "b"},
+			},
+		},
+		{
+			name: "remove matching values",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.set("a", "value-a", c.snapshot())
+				c.set("b", "value-b", c.snapshot())
+				c.removeFunc(func(value string) bool { return value == "value-a" })
+			},
+			lookups: []lookup{
+				{key: "a"},
+				{key: "b", want: "value-b", wantFound: true, wantOk: true},
+			},
+		},
+		{
+			name: "clear values",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				c.setFor("a", "value-a", time.Minute, c.snapshot(), true)
+				c.setFor("b", "value-b", time.Minute, c.snapshot(), false, "alias-b")
+				c.clear()
+			},
+			lookups: []lookup{
+				{key: "a"},
+				{key: "b"},
+			},
+		},
+		{
+			name: "set value read before clear",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				generation := c.snapshot()
+				c.clear()
+				c.set("a", "stale-value-a", generation)
+			},
+			lookups: []lookup{
+				{key: "a"},
+			},
+		},
+		{
+			name: "set value read before invalidation",
+			prepare: func(c *lruCache[string], clock *time.Time) {
+				generation := c.snapshot()
+				c.remove("a")
+				c.set("a", "stale-value-a", generation)
+			},
+			lookups: []lookup{
+				{key: "a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			clock := now
+			c := newLRUCache[string](2, time.Minute, time.Second)
+			c.now = func() time.Time { return clock }
+
+			tt.prepare(c, &clock)
+
+			for _, l := range tt.lookups {
+				got, found, ok := c.get(l.key)
+				if got != l.want || found != l.wantFound || ok != l.wantOk {
+					t.Errorf("get(%q) = %v, %v, %v, want %v, %v, %v", l.key, got, found, ok, l.want, l.wantFound, l.wantOk)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+// CachedTokenStore is an oauth2.TokenStore caching the tokens looked up by
+// their access token in memory, to take the load of resource servers off the
+// database.
+//
+// Tokens are cached until they expire, but at most for the TTL of the cache.
+// Concurrent lookups of the same access token that is not cached are
+// de-duplicated, so only one of them hits the wrapped store. Tokens removed
+// using the cached store are removed from the cache, though tokens removed
+// by other instances are only removed once their cache entry expires. If the
+// wrapped store hashes tokens, the cached tokens lack their codes and refresh
+// tokens, hence removing a token by those invalidates every such token. When
+// the wrapped store detects the reuse of a refresh token, the whole cache is
+// invalidated, as the cached tokens do not tell which family they belong to.
+type CachedTokenStore struct {
+	store oauth2.TokenStore
+	cache *lruCache[oauth2.TokenInfo]
+	group singleflight.Group
+}
+
+// NewCachedTokenStore creates a new CachedTokenStore wrapping the given store.
+// At most maxSize tokens are cached for the given TTL, evicting the least
+// recently used tokens first.
+func NewCachedTokenStore(store oauth2.TokenStore, maxSize int, ttl time.Duration) (*CachedTokenStore, error) {
+	if store == nil {
+		return nil, ErrNoTokenStore
+	}
+
+	if maxSize <= 0 || ttl <= 0 {
+		return nil, ErrInvalidCacheConfig
+	}
+
+	return &CachedTokenStore{
+		store: store,
+		cache: newLRUCache[oauth2.TokenInfo](maxSize, ttl, 0),
+	}, nil
+}
+
+// Create creates a new token in the wrapped store. If the token was loaded by
+// its refresh token, the token it was loaded from is removed from the cache,
+// as the wrapped store may revoke its access token.
+func (s *CachedTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if source, ok := info.(sourcedToken); ok {
+		_, refresh := source.source()
+		defer s.cache.removeAlias(refreshAlias(refresh))
+	}
+
+	return s.store.Create(ctx, info)
+}
+
+// GetByCode returns the token by its authorization code from the wrapped
+// store.
+func (s *CachedTokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.store.GetByCode(ctx, code)
+}
+
+// GetByAccess returns the token by its access token, looking it up in the
+// wrapped store if it is not cached.
+func (s *CachedTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	if info, _, ok := s.cache.get(access); ok {
+		return info, nil
+	}
+
+	// The lookups waiting for the same access token share the result of the
+	// first one. It is not canceled along with the context of the first
+	// lookup, so it does not fail the others.
+	ch := s.group.DoChan(access, func() (any, error) {
+		ctx, cancel := context.WithTimeout(detachedContext{ctx}, sharedLookupTimeout)
+		defer cancel()
+
+		generation := s.cache.snapshot()
+
+		info, err := s.store.GetByAccess(ctx, access)
+		if err != nil || info == nil {
+			return info, err
+		}
+
+		s.set(access, info, generation)
+
+		return info, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil || res.Val == nil {
+			return nil, res.Err
+		}
+
+		info, _ := res.Val.(oauth2.TokenInfo)
+
+		return info, nil
+	}
+}
+
+// sharedLookupTimeout bounds the lookups shared by concurrent callers, which
+// are not canceled along with the context of any of them.
+const sharedLookupTimeout = 30 * time.Second
+
+// detachedContext is a context holding the values of its parent, but not its
+// deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+// set caches the token until its access token expires. Expired tokens are
+// not cached.
+func (s *CachedTokenStore) set(access string, info oauth2.TokenInfo, generation uint64) {
+	ttl := s.cache.ttl
+	if expiresIn := info.GetAccessExpiresIn(); expiresIn > 0 {
+		ttl = info.GetAccessCreateAt().Add(expiresIn).Sub(s.cache.now())
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	var aliases []string
+	if code := info.GetCode(); code != "" {
+		aliases = append(aliases, codeAlias(code))
+	}
+	if refresh := info.GetRefresh(); refresh != "" {
+		aliases = append(aliases, refreshAlias(refresh))
+	}
+
+	// Stores hashing tokens do not return the codes and refresh tokens of the
+	// tokens looked up by their access token, though their creation time tells
+	// they were issued.
+	partial := info.GetCode() == "" && !info.GetCodeCreateAt().IsZero() ||
+		info.GetRefresh() == "" && !info.GetRefreshCreateAt().IsZero()
+
+	s.cache.setFor(access, info, ttl, generation, partial, aliases...)
+}
+
+// GetByRefresh returns the token by its refresh token from the wrapped store.
+// If the wrapped store reports the reuse of the refresh token, the cache is
+// cleared, as the wrapped store revoked the tokens of its family.
+func (s *CachedTokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	info, err := s.store.GetByRefresh(ctx, refresh)
+	if errors.Is(err, ErrRefreshTokenReuse) {
+		s.cache.clear()
+	}
+
+	return info, err
+}
+
+// FindByRefresh returns the token by its refresh token from the wrapped store,
+// using its FindByRefresh method if it implements RefreshTokenFinder.
+func (s *CachedTokenStore) FindByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return findByRefresh(ctx, s.store, refresh)
+}
+
+// RemoveByCode removes the token by its authorization code from the wrapped
+// store and the cache.
+func (s *CachedTokenStore) RemoveByCode(ctx context.Context, code string) error {
+	// The cache is invalidated after the token is removed, so lookups that
+	// started before do not cache the removed token.
+	defer s.cache.removeAlias(codeAlias(code))
+
+	return s.store.RemoveByCode(ctx, code)
+}
+
+// RemoveByAccess removes the token by its access token from the wrapped store
+// and the cache.
+func (s *CachedTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	defer s.cache.remove(access)
+
+	return s.store.RemoveByAccess(ctx, access)
+}
+
+// RemoveByRefresh removes the token by its refresh token from the wrapped
+// store and the cache.
+func (s *CachedTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	defer s.cache.removeAlias(refreshAlias(refresh))
+
+	return s.store.RemoveByRefresh(ctx, refresh)
+}
+
+// UserTokenRemover removes the tokens issued to a user. It is implemented by
+// TokenStore and CachedTokenStore.
+type UserTokenRemover interface {
+	RemoveByUser(ctx context.Context, userID string) error
+}
+
+// RemoveByUser removes the tokens issued to the user from the wrapped store
+// and the cache. It returns ErrUnsupportedOperation if the wrapped store does
+// not implement UserTokenRemover.
+func (s *CachedTokenStore) RemoveByUser(ctx context.Context, userID string) error {
+	remover, ok := s.store.(UserTokenRemover)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+
+	defer s.cache.removeFunc(func(info oauth2.TokenInfo) bool {
+		return info.GetUserID() == userID
+	})
+
+	return remover.RemoveByUser(ctx, userID)
+}
+
+// RemoveByClient removes the tokens issued to the client from the wrapped
+// store and the cache, so it can be used for the cascade delete of clients. It
+// returns ErrUnsupportedOperation if the wrapped store does not implement
+// ClientTokenRemover.
+func (s *CachedTokenStore) RemoveByClient(ctx context.Context, clientID string) error {
+	remover, ok := s.store.(ClientTokenRemover)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+
+	defer s.cache.removeFunc(func(info oauth2.TokenInfo) bool {
+		return info.GetClientID() == clientID
+	})
+
+	return remover.RemoveByClient(ctx, clientID)
+}
+
+// codeAlias and refreshAlias return the cache aliases of the codes and
+// refresh tokens, which are prefixed to never collide.
+func codeAlias(code string) string {
+	return "code:" + code
+}
+
+func refreshAlias(refresh string) string {
+	return "refresh:" + refresh
+}
@@ -0,0 +1,477 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+)
+
+// countingTokenStore counts the lookups by access token of the wrapped store.
+// The lookups wait for release to be closed if it is set.
+type countingTokenStore struct {
+	*fakeTokenStore
+	lookups int32
+	release chan struct{}
+}
+
+func (s *countingTokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
+	atomic.AddInt32(&s.lookups, 1)
+
+	if s.release != nil {
+		<-s.release
+	}
+
+	return s.fakeTokenStore.GetByAccess(ctx, access)
+}
+
+func TestCachedTokenStore_GetByAccess(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		token       *models.Token
+		after       func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error
+		wantLookups int32
+	}{
+		{
+			name:        "cache token",
+			token:       &models.Token{Access: "access", AccessCreateAt: now, AccessExpiresIn: time.Hour},
+			after:       func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error { return nil },
+			wantLookups: 1,
+		},
+		{
+			name:  "cache token without expiry",
+			token: &models.Token{Access: "access"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				*clock = clock.Add(time.Minute)
+				return nil
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "cache token until it expires",
+			token: &models.Token{Access: "access", AccessCreateAt: now, AccessExpiresIn: 30 * time.Second},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				*clock = clock.Add(30 * time.Second)
+				return nil
+			},
+			wantLookups: 2,
+		},
+		{
+			name:        "do not cache expired token",
+			token:       &models.Token{Access: "access", AccessCreateAt: now.Add(-time.Hour), AccessExpiresIn: time.Hour},
+			after:       func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error { return nil },
+			wantLookups: 2,
+		},
+		{
+			name:  "remove by access",
+			token: &models.Token{Access: "access", Refresh: "refresh"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByAccess(ctx, "access")
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "remove by refresh",
+			token: &models.Token{Access: "access", Refresh: "refresh"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByRefresh(ctx, "refresh")
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "remove by code",
+			token: &models.Token{Code: "code", Access: "access"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByCode(ctx, "code")
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "remove by user",
+			token: &models.Token{UserID: "user-id", Access: "access"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByUser(ctx, "user-id")
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "remove by client",
+			token: &models.Token{ClientID: "client-id", Access: "access"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByClient(ctx, "client-id")
+			},
+			wantLookups: 2,
+		},
+		{
+			name:  "remove tokens of other user",
+			token: &models.Token{UserID: "user-id", Access: "access"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByUser(ctx, "other-user-id")
+			},
+			wantLookups: 1,
+		},
+		{
+			name:  "remove other token",
+			token: &models.Token{Access: "access", Refresh: "refresh"},
+			after: func(ctx context.Context, s *CachedTokenStore, clock *time.Time) error {
+				return s.RemoveByRefresh(ctx, "other-refresh")
+			},
+			wantLookups: 1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			clock := now
+
+			store := &countingTokenStore{fakeTokenStore: &fakeTokenStore{tokens: []oauth2.TokenInfo{tt.token}}}
+
+			s, err := NewCachedTokenStore(store, 10, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.cache.now = func() time.Time { return clock }
+
+			if _, err := s.GetByAccess(ctx, "access"); err != nil {
+				t.Fatalf("GetByAccess() error = %v", err)
+			}
+
+			if err := tt.after(ctx, s, &clock); err != nil {
+				t.Fatal(err)
+			}
+
+			// The removed tokens are gone from the wrapped store too.
+			_, _ = s.GetByAccess(ctx, "access")
+
+			if store.lookups != tt.wantLookups {
+				t.Errorf("GetByAccess() lookups = %v, want %v", store.lookups, tt.wantLookups)
+			}
+		})
+	}
+}
+
+func TestCachedTokenStore_GetByAccess_Concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	store := &countingTokenStore{
+		fakeTokenStore: &fakeTokenStore{tokens: []oauth2.TokenInfo{&models.Token{Access: "access"}}},
+		release:        make(chan struct{}),
+	}
+
+	s, err := NewCachedTokenStore(store, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if info, err := s.GetByAccess(ctx, "access"); err != nil || info.GetAccess() != "access" {
+				t.Errorf("GetByAccess() = %v, %v", info, err)
+			}
+		}()
+	}
+
+	// Let the lookups pile up before the first one completes.
+	time.Sleep(10 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+
+	if store.lookups != 1 {
+		t.Errorf("GetByAccess() lookups = %v, want %v", store.lookups, 1)
+	}
+}
+
+func TestCachedTokenStore_GetByAccess_Canceled(t *testing.T) {
+	store := &countingTokenStore{
+		fakeTokenStore: &fakeTokenStore{tokens: []oauth2.TokenInfo{&models.Token{Access: "access"}}},
+		release:        make(chan struct{}),
+	}
+
+	s, err := NewCachedTokenStore(store, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	canceled := make(chan error)
+	go func() {
+		_, err := s.GetByAccess(ctx, "access")
+		canceled <- err
+	}()
+
+	// Let the canceled lookup start the shared one.
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if info, err := s.GetByAccess(context.Background(), "access"); err != nil || info.GetAccess() != "access" {
+			t.Errorf("GetByAccess() = %v, %v", info, err)
+		}
+	}()
+
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("GetByAccess() error = %v, want %v", err, context.Canceled)
+	}
+
+	close(store.release)
+	<-done
+
+	if store.lookups != 1 {
+		t.Errorf("GetByAccess() lookups = %v, want %v", store.lookups, 1)
+	}
+}
+
+func TestCachedTokenStore_HashedTokens(t *testing.T) {
+	ctx := context.Background()
+
+	tokens, err := NewTokenStore(
+		WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+		WithTokenStoreEnsureSchema(nil),
+		WithTokenStoreHashKey([]byte("secret-key")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewCachedTokenStore(tokens, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Create(ctx, &models.Token{
+		ClientID:         "client-id",
+		Access:           "access",
+		AccessCreateAt:   time.Now(),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "refresh",
+		RefreshCreateAt:  time.Now(),
+		RefreshExpiresIn: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatalf("RemoveByRefresh() error = %v", err)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestCachedTokenStore_RefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+
+	tokens, err := NewTokenStore(
+		WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+		WithTokenStoreEnsureSchema(nil),
+		WithTokenStoreRefreshRotation(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewCachedTokenStore(tokens, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, &models.Token{Access: "access", Refresh: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.GetByRefresh(ctx, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.SetAccess("rotated-access")
+	info.SetRefresh("rotated-refresh")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token refreshed from the rotated refresh token is cached.
+	if _, err := s.GetByAccess(ctx, "rotated-access"); err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	// FindByRefresh does not revoke the family.
+	if _, err := s.FindByRefresh(ctx, "refresh"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("FindByRefresh() error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if _, err := s.GetByAccess(ctx, "rotated-access"); err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	if _, err := s.GetByRefresh(ctx, "refresh"); !errors.Is(err, ErrRefreshTokenReuse) {
+		t.Errorf("GetByRefresh() error = %v, want %v", err, ErrRefreshTokenReuse)
+	}
+
+	if _, err := s.GetByAccess(ctx, "rotated-access"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestCachedTokenStore_GetByCode(t *testing.T) {
+	ctx := context.Background()
+	token := &models.Token{Code: "code"}
+
+	s, err := NewCachedTokenStore(&fakeTokenStore{tokens: []oauth2.TokenInfo{token}}, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.GetByCode(ctx, "code"); err != nil || got != token {
+		t.Errorf("GetByCode() = %v, %v, want %v", got, err, token)
+	}
+
+	if _, err := s.GetByCode(ctx, "other-code"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetByCode() error = %v, want %v", err, ErrTokenNotFound)
+	}
+}
+
+func TestCachedTokenStore_FindByRefresh(t *testing.T) {
+	ctx := context.Background()
+	token := &models.Token{Refresh: "refresh"}
+
+	tests := []struct {
+		name    string
+		store   oauth2.TokenStore
+		want    oauth2.TokenInfo
+		wantErr error
+	}{
+		{
+			name:  "find token using GetByRefresh",
+			store: &fakeTokenStore{tokens: []oauth2.TokenInfo{token}},
+			want:  token,
+		},
+		{
+			name:  "find token using FindByRefresh",
+			store: &findingTokenStore{reusedTokenStore: reusedTokenStore{fakeTokenStore{tokens: []oauth2.TokenInfo{token}}}},
+			want:  token,
+		},
+		{
+			name:    "find reused token using GetByRefresh",
+			store:   new(reusedTokenStore),
+			wantErr: ErrRefreshTokenReuse,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s, err := NewCachedTokenStore(tt.store, 10, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.FindByRefresh(ctx, "refresh")
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("FindByRefresh() = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// findingTokenStore is a token store detecting the reuse of every refresh
+// token, though finding them without detection.
+type findingTokenStore struct {
+	reusedTokenStore
+}
+
+func (s *findingTokenStore) FindByRefresh(_ context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.get(func(info oauth2.TokenInfo) bool { return info.GetRefresh() == refresh })
+}
+
+func TestCachedTokenStore_RemoveByOwner_Unsupported(t *testing.T) {
+	ctx := context.Background()
+
+	// The wrapped store only implements oauth2.TokenStore.
+	s, err := NewCachedTokenStore(struct{ oauth2.TokenStore }{new(fakeTokenStore)}, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveByUser(ctx, "user-id"); err != ErrUnsupportedOperation {
+		t.Errorf("RemoveByUser() error = %v, want %v", err, ErrUnsupportedOperation)
+	}
+
+	if err := s.RemoveByClient(ctx, "client-id"); err != ErrUnsupportedOperation {
+		t.Errorf("RemoveByClient() error = %v, want %v", err, ErrUnsupportedOperation)
+	}
+}
+
+func TestNewCachedTokenStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   oauth2.TokenStore
+		maxSize int
+		ttl     time.Duration
+		wantErr error
+	}{
+		{
+			name:    "create store",
+			store:   new(fakeTokenStore),
+			maxSize: 10,
+			ttl:     time.Minute,
+		},
+		{
+			name:    "create store without token store",
+			maxSize: 10,
+			ttl:     time.Minute,
+			wantErr: ErrNoTokenStore,
+		},
+		{
+			name:    "create store with invalid size",
+			store:   new(fakeTokenStore),
+			ttl:     time.Minute,
+			wantErr: ErrInvalidCacheConfig,
+		},
+		{
+			name:    "create store with invalid ttl",
+			store:   new(fakeTokenStore),
+			maxSize: 10,
+			wantErr: ErrInvalidCacheConfig,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := NewCachedTokenStore(tt.store, tt.maxSize, tt.ttl); err != tt.wantErr {
+				t.Errorf("NewCachedTokenStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
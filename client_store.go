@@ -2,11 +2,15 @@ package arangostore
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"time"
 
 	arangoDriver "github.com/arangodb/go-driver"
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -43,67 +47,701 @@ func WithClientStoreDatabase(db arangoDriver.Database) ClientStoreOption {
 	}
 }
 
+// WithClientStoreEnsureSchema configures the ClientStore to create its
+// collection on construction if it is missing. If report is not nil, it is
+// populated with the changes made.
+func WithClientStoreEnsureSchema(report *SchemaReport) ClientStoreOption {
+	return func(s *ClientStore) error {
+		s.ensureSchema = true
+		s.schemaReport = report
+
+		return nil
+	}
+}
+
+// WithClientStoreNotFoundAsNil configures the ClientStore to return a nil
+// client and a nil error instead of ErrClientNotFound when the client does not
+// exist. This is the behavior the go-oauth2 manager expects to report invalid
+// clients.
+func WithClientStoreNotFoundAsNil() ClientStoreOption {
+	return func(s *ClientStore) error {
+		s.notFoundAsNil = true
+
+		return nil
+	}
+}
+
+// WithClientStoreSecretHasher configures the ClientStore to store the hash of
+// the client secrets instead of the secrets themselves. The clients returned
+// by the store implement oauth2.ClientPasswordVerifier to verify the secrets
+// against the stored hashes. Clients stored with a plaintext secret keep
+// working and their secret is replaced by its hash on the next successful
+// verification.
+func WithClientStoreSecretHasher(hasher SecretHasher) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if hasher == nil {
+			return ErrNoSecretHasher
+		}
+
+		s.hasher = hasher
+
+		return nil
+	}
+}
+
+// WithClientStoreEncryptor configures the ClientStore to encrypt the data of
+// the clients using the given encryptor. As the data holds the secret of the
+// client, the secret is not stored separately. Clients stored before
+// encryption was enabled can still be read and encrypted using Reencrypt.
+func WithClientStoreEncryptor(enc Encryptor) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if enc == nil {
+			return ErrNoEncryptor
+		}
+
+		s.encryptor = enc
+
+		return nil
+	}
+}
+
+// ClientTokenRemover removes the tokens issued to a client. It is implemented
+// by TokenStore and CachedTokenStore.
+type ClientTokenRemover interface {
+	RemoveByClient(ctx context.Context, clientID string) error
+}
+
+// WithClientStoreCascadeDelete configures the ClientStore to remove the tokens
+// issued to a client from the given token store when the client is deleted.
+func WithClientStoreCascadeDelete(tokens ClientTokenRemover) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if tokens == nil {
+			return ErrNoTokenStore
+		}
+
+		s.tokens = tokens
+
+		return nil
+	}
+}
+
+// WithClientStoreDataAsObject configures the ClientStore to store the data of
+// the clients as nested JSON object instead of a base64 encoded string, so it
+// can be inspected and queried. Both forms are read regardless of the option.
+// Encrypted data is always stored as string.
+func WithClientStoreDataAsObject() ClientStoreOption {
+	return func(s *ClientStore) error {
+		s.dataAsObject = true
+
+		return nil
+	}
+}
+
+// WithClientStoreCache configures the ClientStore to cache the clients looked
+// up by GetByID in memory. At most maxSize clients are cached for the given
+// TTL, evicting the least recently used clients first. If negativeTTL is not
+// zero, client IDs that do not exist are cached as well for the given
+// duration, so guessing client IDs does not hit the database. Clients changed
+// using the store are removed from the cache.
+func WithClientStoreCache(maxSize int, ttl time.Duration, negativeTTL time.Duration) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if maxSize <= 0 || ttl <= 0 || negativeTTL < 0 {
+			return ErrInvalidCacheConfig
+		}
+
+		s.cache = newLRUCache[oauth2.ClientInfo](maxSize, ttl, negativeTTL)
+
+		return nil
+	}
+}
+
+// WithClientStoreTimeout configures the ClientStore to cancel every operation
+// after the given timeout, unless the context passed to it has a deadline
+// already.
+func WithClientStoreTimeout(timeout time.Duration) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if timeout <= 0 {
+			return ErrInvalidTimeout
+		}
+
+		s.timeout = timeout
+
+		return nil
+	}
+}
+
+// WithClientStoreRetry configures the ClientStore to retry the operations that
+// are safe to repeat when they fail with a transient error, according to the
+// given policy.
+func WithClientStoreRetry(policy RetryPolicy) ClientStoreOption {
+	return func(s *ClientStore) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+
+		s.retry = &policy
+
+		return nil
+	}
+}
+
+// WithClientStoreTelemetry configures the ClientStore to record an
+// OpenTelemetry span, the duration and the errors of every operation. The
+// spans and metrics tell the operation, the collection and whether a lookup
+// found anything, but never the values looked up. The global providers are
+// used if a provider is nil.
+func WithClientStoreTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) ClientStoreOption {
+	return func(s *ClientStore) error {
+		t, err := newTelemetry(tracerProvider, meterProvider)
+		if err != nil {
+			return err
+		}
+
+		s.telemetry = t
+
+		return nil
+	}
+}
+
 // ClientStoreItem data item
 type ClientStoreItem struct {
-	Key    string `json:"_key"`
-	Secret string `json:"secret"`
-	Domain string `json:"domain"`
-	Data   []byte `json:"data"`
+	Key    string          `json:"_key"`
+	Secret string          `json:"secret"`
+	Domain string          `json:"domain"`
+	Data   json.RawMessage `json:"data"`
+	KeyID  string          `json:"key_id,omitempty"`
 }
 
 // ClientStore is a data struct that stores oauth2 client information.
 type ClientStore struct {
-	db         arangoDriver.Database
-	collection string
+	db            arangoDriver.Database
+	collection    string
+	ensureSchema  bool
+	schemaReport  *SchemaReport
+	notFoundAsNil bool
+	hasher        SecretHasher
+	encryptor     Encryptor
+	dataAsObject  bool
+	tokens        ClientTokenRemover
+	cache         *lruCache[oauth2.ClientInfo]
+	timeout       time.Duration
+	retry         *RetryPolicy
+	telemetry     *telemetry
 }
 
-// Create creates a new client in the store.
-func (s *ClientStore) Create(info oauth2.ClientInfo) error {
+// HashedClient is the client information returned by a ClientStore that is
+// configured to hash client secrets. GetSecret returns the stored hash, while
+// VerifyPassword verifies a secret against it.
+type HashedClient struct {
+	models.Client
+	store *ClientStore
+	rev   string
+}
+
+// VerifyPassword implements oauth2.ClientPasswordVerifier. The client is not
+// modified, so it can be verified concurrently.
+func (c *HashedClient) VerifyPassword(secret string) bool {
+	// Clients without a secret, like public clients, are stored as they are,
+	// so there is no secret to upgrade.
+	if c.Secret == "" {
+		return secret == ""
+	}
+
+	if c.store.hasher.IsHash(c.Secret) {
+		ok, err := c.store.hasher.Verify(c.Secret, secret)
+		return err == nil && ok
+	}
+
+	// The client was stored before secret hashing was enabled.
+	if subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) != 1 {
+		return false
+	}
+
+	ctx, cancel := withTimeout(context.Background(), c.store.timeout)
+	defer cancel()
+
+	// Upgrading the secret is best effort, the secret is valid nevertheless.
+	_ = c.store.upgradeSecret(ctx, c.Client, c.rev)
+
+	return true
+}
+
+// EnsureSchema creates the collection of the store if it does not exist yet.
+// Clients are looked up by their document key, hence no additional indexes
+// are needed. It is safe to call multiple times.
+func (s *ClientStore) EnsureSchema(ctx context.Context) (_ *SchemaReport, err error) {
+	ctx, op := s.telemetry.start(ctx, "EnsureSchema", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("EnsureSchema", s.collection, &err)
+
+	report := new(SchemaReport)
+
+	if _, err := ensureCollection(ctx, s.db, s.collection, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// newItem returns the document to store for the client, hashing its secret and
+// encrypting its data if the store is configured to do so.
+func (s *ClientStore) newItem(info oauth2.ClientInfo) (*ClientStoreItem, error) {
+	if s.hasher != nil && info.GetSecret() != "" && !s.hasher.IsHash(info.GetSecret()) {
+		hash, err := s.hasher.Hash(info.GetSecret())
+		if err != nil {
+			return nil, err
+		}
+
+		info = &models.Client{
+			ID:     info.GetID(),
+			Secret: hash,
+			Domain: info.GetDomain(),
+			Public: info.IsPublic(),
+			UserID: info.GetUserID(),
+		}
+	}
+
 	data, err := json.Marshal(info)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	coll, err := s.db.Collection(context.Background(), s.collection)
+	keyID, data, err := encryptData(s.encryptor, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	doc := &ClientStoreItem{
 		Key:    info.GetID(),
 		Secret: info.GetSecret(),
 		Domain: info.GetDomain(),
-		Data:   data,
+		KeyID:  keyID,
+	}
+
+	if keyID != "" {
+		// The secret is part of the encrypted data.
+		doc.Secret = ""
+	}
+
+	// Encrypted data is not JSON, hence it is always stored as string.
+	doc.Data, err = encodeData(data, s.dataAsObject && keyID == "")
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (s *ClientStore) decodeClient(doc *ClientStoreItem, meta arangoDriver.DocumentMeta) (oauth2.ClientInfo, error) {
+	data, err := decodeData(doc.Data)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = coll.CreateDocument(context.Background(), doc)
+	data, err = decryptData(s.encryptor, doc.KeyID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var info models.Client
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+
+	if s.hasher != nil {
+		return &HashedClient{Client: info, store: s, rev: meta.Rev}, nil
+	}
+
+	return &info, nil
+}
+
+// upgradeSecret replaces the plaintext secret of the client with its hash,
+// unless the client was changed since it was read at the given revision. The
+// client is copied, as the same client may be verified concurrently or held by
+// the cache, which is invalidated instead.
+func (s *ClientStore) upgradeSecret(ctx context.Context, client models.Client, rev string) error {
+	hash, err := s.hasher.Hash(client.Secret)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	upgraded := client
+	upgraded.Secret = hash
+
+	doc, err := s.newItem(&upgraded)
+	if err != nil {
+		return err
+	}
+
+	coll, err := s.db.Collection(ctx, s.collection)
+	if err != nil {
+		return err
+	}
+
+	_, err = coll.ReplaceDocument(arangoDriver.WithRevision(ctx, rev), doc.Key, doc)
+	s.Invalidate(doc.Key)
+
+	return err
 }
 
-// GetByID returns the client information by key from the store.
-func (s *ClientStore) GetByID(ctx context.Context, key string) (oauth2.ClientInfo, error) {
+// Create creates a new client in the store. It calls CreateWithContext with
+// the background context.
+func (s *ClientStore) Create(info oauth2.ClientInfo) error {
+	return s.CreateWithContext(context.Background(), info)
+}
+
+// CreateWithContext creates a new client in the store.
+func (s *ClientStore) CreateWithContext(ctx context.Context, info oauth2.ClientInfo) (err error) {
+	ctx, op := s.telemetry.start(ctx, "CreateWithContext", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("CreateWithContext", s.collection, &err)
+
+	doc, err := s.newItem(info)
+	if err != nil {
+		return err
+	}
+
 	coll, err := s.db.Collection(ctx, s.collection)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	_, err = coll.CreateDocument(ctx, doc)
+	s.Invalidate(doc.Key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// notFound returns the result of a lookup of a client that does not exist.
+func (s *ClientStore) notFound(err error) (oauth2.ClientInfo, error) {
+	if s.notFoundAsNil {
+		return nil, nil
+	}
+
+	return nil, err
+}
+
+// readDocument reads the document of the client with the given key.
+func (s *ClientStore) readDocument(ctx context.Context, key string, doc *ClientStoreItem) (meta arangoDriver.DocumentMeta, err error) {
+	err = s.retry.do(ctx, func() error {
+		coll, err := s.db.Collection(ctx, s.collection)
+		if err != nil {
+			return err
+		}
+
+		meta, err = coll.ReadDocument(ctx, key, doc)
+		return err
+	})
+
+	return meta, err
+}
+
+// GetByID returns the client information by key from the store.
+func (s *ClientStore) GetByID(ctx context.Context, key string) (_ oauth2.ClientInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "GetByID", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("GetByID", s.collection, &err)
+
+	var generation uint64
+
+	if s.cache != nil {
+		if client, found, ok := s.cache.get(key); ok {
+			op.setHit(found)
+
+			if !found {
+				return s.notFound(ErrClientNotFound)
+			}
+
+			return client, nil
+		}
+
+		generation = s.cache.snapshot()
 	}
 
 	var client ClientStoreItem
-	_, err = coll.ReadDocument(ctx, key, &client)
+	meta, err := s.readDocument(ctx, key, &client)
 	if err != nil {
+		if arangoDriver.IsNotFoundGeneral(err) {
+			op.setHit(false)
+
+			if s.cache != nil {
+				s.cache.setMissing(key, generation)
+			}
+
+			return s.notFound(&sentinelError{sentinel: ErrClientNotFound, cause: err})
+		}
+
 		return nil, err
 	}
 
-	var info models.Client
-	err = json.Unmarshal(client.Data, &info)
+	op.setHit(true)
+
+	info, err := s.decodeClient(&client, meta)
 	if err != nil {
 		return nil, err
 	}
 
-	return &info, nil
+	if s.cache != nil {
+		s.cache.set(key, info, generation)
+	}
+
+	return info, nil
+}
+
+// Invalidate removes the client from the cache of the store, so it is read
+// from the database on the next lookup. The store invalidates the clients it
+// changes itself, hence this is only needed if the clients are changed
+// elsewhere, like by another instance of the application.
+func (s *ClientStore) Invalidate(key string) {
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+}
+
+// GetWithRevision returns the client information by key from the store along
+// with the revision it was read at, which can be passed to Update to detect
+// concurrent modifications. The client is always read from the database, so
+// its revision is current.
+func (s *ClientStore) GetWithRevision(ctx context.Context, key string) (_ oauth2.ClientInfo, _ string, err error) {
+	ctx, op := s.telemetry.start(ctx, "GetWithRevision", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("GetWithRevision", s.collection, &err)
+
+	var client ClientStoreItem
+	meta, err := s.readDocument(ctx, key, &client)
+	if err != nil {
+		if arangoDriver.IsNotFoundGeneral(err) {
+			op.setHit(false)
+			return nil, "", &sentinelError{sentinel: ErrClientNotFound, cause: err}
+		}
+
+		return nil, "", err
+	}
+
+	op.setHit(true)
+
+	info, err := s.decodeClient(&client, meta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return info, meta.Rev, nil
+}
+
+// Update replaces the client in the store and returns its new revision. If
+// rev is not empty, the client is only replaced if its current revision
+// matches rev, otherwise ErrRevisionMismatch is returned. Pass the revision
+// returned by GetWithRevision along with the client that was modified.
+func (s *ClientStore) Update(ctx context.Context, info oauth2.ClientInfo, rev string) (_ string, err error) {
+	ctx, op := s.telemetry.start(ctx, "Update", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Update", s.collection, &err)
+
+	doc, err := s.newItem(info)
+	if err != nil {
+		return "", err
+	}
+
+	replaceCtx, retry := ctx, s.retry
+	if rev != "" {
+		replaceCtx = arangoDriver.WithRevision(ctx, rev)
+		// The revision changes if an attempt succeeds on the server, hence a
+		// retry would report a mismatch.
+		retry = nil
+	}
+
+	var meta arangoDriver.DocumentMeta
+	err = retry.do(ctx, func() error {
+		coll, err := s.db.Collection(ctx, s.collection)
+		if err != nil {
+			return err
+		}
+
+		meta, err = coll.ReplaceDocument(replaceCtx, doc.Key, doc)
+		return err
+	})
+	s.Invalidate(doc.Key)
+	if err != nil {
+		switch {
+		case arangoDriver.IsPreconditionFailed(err):
+			return "", &sentinelError{sentinel: ErrRevisionMismatch, cause: err}
+		case arangoDriver.IsNotFoundGeneral(err):
+			return "", &sentinelError{sentinel: ErrClientNotFound, cause: err}
+		default:
+			return "", err
+		}
+	}
+
+	return meta.Rev, nil
+}
+
+// Delete removes the client from the store. If the store is configured to
+// cascade deletes, the tokens issued to the client are removed as well. The
+// tokens are removed after the client, so no new tokens can be issued in the
+// meantime, and even if the client does not exist, so a failed Delete can be
+// retried.
+func (s *ClientStore) Delete(ctx context.Context, key string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "Delete", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Delete", s.collection, &err)
+
+	var failed bool
+	err = s.retry.do(ctx, func() error {
+		coll, err := s.db.Collection(ctx, s.collection)
+		if err != nil {
+			return err
+		}
+
+		// A removal that failed may have removed the client on the server
+		// nevertheless, hence the client not being found by a later attempt
+		// is not an error.
+		_, err = coll.RemoveDocument(ctx, key)
+		if failed && arangoDriver.IsNotFoundGeneral(err) {
+			return nil
+		}
+
+		failed = err != nil
+		return err
+	})
+	s.Invalidate(key)
+	if err != nil && !arangoDriver.IsNotFoundGeneral(err) {
+		return err
+	}
+
+	if s.tokens != nil {
+		if err := s.tokens.RemoveByClient(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	if err != nil {
+		return &sentinelError{sentinel: ErrClientNotFound, cause: err}
+	}
+
+	return nil
+}
+
+// Exists returns whether the client exists in the store.
+func (s *ClientStore) Exists(ctx context.Context, key string) (_ bool, err error) {
+	ctx, op := s.telemetry.start(ctx, "Exists", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Exists", s.collection, &err)
+
+	var exists bool
+	err = s.retry.do(ctx, func() error {
+		coll, err := s.db.Collection(ctx, s.collection)
+		if err != nil {
+			return err
+		}
+
+		exists, err = coll.DocumentExists(ctx, key)
+		return err
+	})
+
+	return exists, err
+}
+
+// List returns at most limit clients ordered by their ID, starting after the
+// given cursor. The returned cursor can be used to fetch the next page and is
+// empty if there are no more clients. Pass an empty cursor to get the first
+// page.
+func (s *ClientStore) List(ctx context.Context, cursor string, limit int) (clients []oauth2.ClientInfo, next string, err error) {
+	ctx, op := s.telemetry.start(ctx, "List", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("List", s.collection, &err)
+
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	query := "FOR doc IN @@collection FILTER doc._key > @cursor SORT doc._key LIMIT @limit RETURN doc"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		"cursor":      cursor,
+		"limit":       limit,
+	}
+
+	err = s.retry.do(ctx, func() error {
+		var err error
+		clients, next, err = s.queryPage(ctx, query, bindVars, limit)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return clients, next, nil
+}
+
+// queryPage returns the clients returned by the query, which returns at most
+// limit documents ordered by their key, and the cursor of the next page.
+func (s *ClientStore) queryPage(ctx context.Context, query string, bindVars map[string]any, limit int) (clients []oauth2.ClientInfo, next string, err error) {
+	c, err := s.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, "", err
+	}
+	defer closeCursor(c, &err)
+
+	clients = make([]oauth2.ClientInfo, 0, limit)
+	for c.HasMore() {
+		var doc ClientStoreItem
+		meta, err := c.ReadDocument(ctx, &doc)
+		if err != nil {
+			return nil, "", err
+		}
+
+		info, err := s.decodeClient(&doc, meta)
+		if err != nil {
+			return nil, "", err
+		}
+
+		clients = append(clients, info)
+		next = doc.Key
+	}
+
+	if len(clients) < limit {
+		next = ""
+	}
+
+	return clients, next, nil
+}
+
+// Reencrypt encrypts the data of every client that is not encrypted using the
+// current key of the encryptor, including clients stored before encryption
+// was enabled. It returns the number of re-encrypted clients.
+func (s *ClientStore) Reencrypt(ctx context.Context) (_ int, err error) {
+	ctx, op := s.telemetry.start(ctx, "Reencrypt", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Reencrypt", s.collection, &err)
+
+	if s.encryptor == nil {
+		return 0, ErrNoEncryptor
+	}
+
+	// The secret is part of the encrypted data, hence it must not be stored
+	// separately.
+	return reencrypt(ctx, s.db, s.collection, s.encryptor, map[string]any{"secret": ""})
 }
 
 // NewClientStore creates a new ClientStore.
@@ -122,5 +760,16 @@ func NewClientStore(opts ...ClientStoreOption) (*ClientStore, error) {
 		return nil, ErrNoDatabase
 	}
 
+	if s.ensureSchema {
+		report, err := s.EnsureSchema(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		if s.schemaReport != nil {
+			*s.schemaReport = *report
+		}
+	}
+
 	return s, nil
 }
@@ -3,13 +3,18 @@ package arangostore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/arangodb/go-driver"
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestNewClientStore(t *testing.T) {
@@ -76,6 +81,68 @@ func TestNewClientStore(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "new client store with invalid token store",
+			args: args{
+				opts: []ClientStoreOption{
+					WithClientStoreDatabase(new(MockArangoDB)),
+					WithClientStoreCascadeDelete(nil),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new client store with timeout",
+			args: args{
+				opts: []ClientStoreOption{
+					WithClientStoreDatabase(new(MockArangoDB)),
+					WithClientStoreTimeout(time.Second),
+				},
+			},
+			want: &ClientStore{
+				db:         new(MockArangoDB),
+				collection: DefaultClientStoreCollection,
+				timeout:    time.Second,
+			},
+		},
+		{
+			name: "new client store with invalid timeout",
+			args: args{
+				opts: []ClientStoreOption{
+					WithClientStoreDatabase(new(MockArangoDB)),
+					WithClientStoreTimeout(-time.Second),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new client store with retry",
+			args: args{
+				opts: []ClientStoreOption{
+					WithClientStoreDatabase(new(MockArangoDB)),
+					WithClientStoreRetry(DefaultRetryPolicy()),
+				},
+			},
+			want: &ClientStore{
+				db:         new(MockArangoDB),
+				collection: DefaultClientStoreCollection,
+				retry: &RetryPolicy{
+					MaxAttempts:    3,
+					InitialBackoff: 50 * time.Millisecond,
+					MaxBackoff:     time.Second,
+				},
+			},
+		},
+		{
+			name: "new client store with invalid retry",
+			args: args{
+				opts: []ClientStoreOption{
+					WithClientStoreDatabase(new(MockArangoDB)),
+					WithClientStoreRetry(RetryPolicy{}),
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -116,6 +183,11 @@ func TestClientStore_Create(t *testing.T) {
 						t.Fatal(err)
 					}
 
+					data, err = encodeData(data, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+
 					coll := new(MockArangoCollection)
 					coll.On("CreateDocument", context.Background(), &ClientStoreItem{
 						Key:    info.GetID(),
@@ -172,6 +244,11 @@ func TestClientStore_Create(t *testing.T) {
 						t.Fatal(err)
 					}
 
+					data, err = encodeData(data, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+
 					coll := new(MockArangoCollection)
 					coll.On("CreateDocument", context.Background(), &ClientStoreItem{
 						Key:    info.GetID(),
@@ -214,21 +291,125 @@ func TestClientStore_Create(t *testing.T) {
 	}
 }
 
+func TestClientStore_CreateWithContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+
+	// The context passed to the driver must be derived from the caller's.
+	fromCaller := func(wantDeadline bool) any {
+		return mock.MatchedBy(func(c context.Context) bool {
+			_, hasDeadline := c.Deadline()
+			return c.Value(ctxKey{}) == "value" && hasDeadline == wantDeadline
+		})
+	}
+
+	tests := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		timeout time.Duration
+		db      func() driver.Database
+		wantErr bool
+	}{
+		{
+			name: "create client",
+			ctx:  func() (context.Context, context.CancelFunc) { return ctx, func() {} },
+			db: func() driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("CreateDocument", fromCaller(false), mock.Anything).Return(driver.DocumentMeta{}, nil)
+
+				db := new(MockArangoDB)
+				db.On("Collection", fromCaller(false), DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+		},
+		{
+			name:    "create client with default timeout",
+			ctx:     func() (context.Context, context.CancelFunc) { return ctx, func() {} },
+			timeout: time.Minute,
+			db: func() driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("CreateDocument", fromCaller(true), mock.Anything).Return(driver.DocumentMeta{}, nil)
+
+				db := new(MockArangoDB)
+				db.On("Collection", fromCaller(true), DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+		},
+		{
+			name:    "create client with deadline",
+			ctx:     func() (context.Context, context.CancelFunc) { return context.WithTimeout(ctx, time.Hour) },
+			timeout: time.Minute,
+			db: func() driver.Database {
+				// The deadline of the caller takes precedence.
+				beforeDefault := mock.MatchedBy(func(c context.Context) bool {
+					deadline, ok := c.Deadline()
+					return ok && time.Until(deadline) > time.Minute
+				})
+
+				coll := new(MockArangoCollection)
+				coll.On("CreateDocument", beforeDefault, mock.Anything).Return(driver.DocumentMeta{}, nil)
+
+				db := new(MockArangoDB)
+				db.On("Collection", beforeDefault, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+		},
+		{
+			name: "create client with collection error",
+			ctx:  func() (context.Context, context.CancelFunc) { return ctx, func() {} },
+			db: func() driver.Database {
+				db := new(MockArangoDB)
+				db.On("Collection", fromCaller(false), DefaultClientStoreCollection).Return(nil, context.Canceled)
+
+				return db
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			db := tt.db()
+			s := &ClientStore{
+				db:         db,
+				collection: DefaultClientStoreCollection,
+				timeout:    tt.timeout,
+			}
+
+			err := s.CreateWithContext(ctx, &models.Client{ID: "client-id", Secret: "client-secret"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateWithContext() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			db.(*MockArangoDB).AssertExpectations(t)
+		})
+	}
+}
+
 func TestClientStore_GetByID(t *testing.T) {
 	type fields struct {
-		db         func(ctx context.Context, key string, doc *ClientStoreItem) driver.Database
-		collection string
+		db            func(ctx context.Context, key string, doc *ClientStoreItem) driver.Database
+		collection    string
+		notFoundAsNil bool
 	}
 	type args struct {
 		ctx context.Context
 		key string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    oauth2.ClientInfo
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		want      oauth2.ClientInfo
+		wantErr   bool
+		wantErrIs error
 	}{
 		{
 			name: "get client by id",
@@ -275,6 +456,55 @@ func TestClientStore_GetByID(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "get client by id not found",
+			fields: fields{
+				db: func(ctx context.Context, key string, doc *ClientStoreItem) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("ReadDocument", ctx, key, doc).Return(nil, driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusNotFound,
+						ErrorNum: driver.ErrArangoDocumentNotFound,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			wantErr:   true,
+			wantErrIs: ErrClientNotFound,
+		},
+		{
+			name: "get client by id not found as nil",
+			fields: fields{
+				db: func(ctx context.Context, key string, doc *ClientStoreItem) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("ReadDocument", ctx, key, doc).Return(nil, driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusNotFound,
+						ErrorNum: driver.ErrArangoDocumentNotFound,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection:    DefaultClientStoreCollection,
+				notFoundAsNil: true,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+		},
 		{
 			name: "get client by id with read document error",
 			fields: fields{
@@ -316,17 +546,956 @@ func TestClientStore_GetByID(t *testing.T) {
 			}
 
 			s := &ClientStore{
-				db:         tt.fields.db(tt.args.ctx, tt.args.key, &storeItem),
-				collection: tt.fields.collection,
+				db:            tt.fields.db(tt.args.ctx, tt.args.key, &storeItem),
+				collection:    tt.fields.collection,
+				notFoundAsNil: tt.fields.notFoundAsNil,
 			}
 			got, err := s.GetByID(tt.args.ctx, tt.args.key)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetByID() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("GetByID() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("GetByID() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestClientStore_GetWithRevision(t *testing.T) {
+	client := &models.Client{ID: "client-id", Secret: "client-secret", Domain: "example.com"}
+
+	data, err := json.Marshal(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		db        func(ctx context.Context) driver.Database
+		want      oauth2.ClientInfo
+		wantRev   string
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "get client with revision",
+			db: func(ctx context.Context) driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("ReadDocument", ctx, "client-id", mock.Anything).Return(&ClientStoreItem{
+					Key:  "client-id",
+					Data: data,
+				}, driver.DocumentMeta{Key: "client-id", Rev: "rev-1"}, nil)
+
+				db := new(MockArangoDB)
+				db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+			want:    client,
+			wantRev: "rev-1",
+		},
+		{
+			name: "get client with revision not found",
+			db: func(ctx context.Context) driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("ReadDocument", ctx, "client-id", mock.Anything).Return(nil, driver.DocumentMeta{}, driver.ArangoError{
+					HasError: true,
+					Code:     http.StatusNotFound,
+					ErrorNum: driver.ErrArangoDocumentNotFound,
+				})
+
+				db := new(MockArangoDB)
+				db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+			wantErr:   true,
+			wantErrIs: ErrClientNotFound,
+		},
+		{
+			name: "get client with revision with read document error",
+			db: func(ctx context.Context) driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("ReadDocument", ctx, "client-id", mock.Anything).Return(nil, driver.DocumentMeta{}, fmt.Errorf("error"))
+
+				db := new(MockArangoDB)
+				db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			// The cache is bypassed, so the revision is current.
+			cache := newLRUCache[oauth2.ClientInfo](10, time.Minute, 0)
+			cache.set("client-id", &models.Client{ID: "client-id"}, cache.snapshot())
+
+			s := &ClientStore{
+				db:         tt.db(ctx),
+				collection: DefaultClientStoreCollection,
+				cache:      cache,
+			}
+			got, rev, err := s.GetWithRevision(ctx, "client-id")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetWithRevision() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("GetWithRevision() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) || rev != tt.wantRev {
+				t.Errorf("GetWithRevision() got = %v, %v, want %v, %v", got, rev, tt.want, tt.wantRev)
+			}
+		})
+	}
+}
+
+func TestClientStore_EnsureSchema(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx context.Context
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *SchemaReport
+		wantErr bool
+	}{
+		{
+			name: "ensure schema with existing collection",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultClientStoreCollection).Return(true, nil)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(new(MockArangoCollection), nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: &SchemaReport{},
+		},
+		{
+			name: "ensure schema with missing collection",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultClientStoreCollection).Return(false, nil)
+					db.On("CreateCollection", ctx, DefaultClientStoreCollection, (*driver.CreateCollectionOptions)(nil)).Return(new(MockArangoCollection), nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: &SchemaReport{
+				CollectionCreated: true,
+			},
+		},
+		{
+			name: "ensure schema with create collection error",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultClientStoreCollection).Return(false, nil)
+					db.On("CreateCollection", ctx, DefaultClientStoreCollection, (*driver.CreateCollectionOptions)(nil)).Return(new(MockArangoCollection), fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.ctx),
+				collection: tt.fields.collection,
+			}
+			got, err := s.EnsureSchema(tt.args.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EnsureSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnsureSchema() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStore_Update(t *testing.T) {
+	type fields struct {
+		db         func(info oauth2.ClientInfo) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx  context.Context
+		info oauth2.ClientInfo
+		rev  string
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		want      string
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "update client",
+			fields: fields{
+				db: func(info oauth2.ClientInfo) driver.Database {
+					data, err := json.Marshal(info)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					data, err = encodeData(data, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					coll := new(MockArangoCollection)
+					coll.On("ReplaceDocument", mock.Anything, info.GetID(), &ClientStoreItem{
+						Key:    info.GetID(),
+						Secret: info.GetSecret(),
+						Domain: info.GetDomain(),
+						Data:   data,
+					}).Return(driver.DocumentMeta{Rev: "rev-2"}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Client{
+					ID:     "client-id",
+					Secret: "client-secret",
+					Domain: "example.com",
+				},
+				rev: "rev-1",
+			},
+			want: "rev-2",
+		},
+		{
+			name: "update client with revision mismatch",
+			fields: fields{
+				db: func(info oauth2.ClientInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("ReplaceDocument", mock.Anything, info.GetID(), mock.Anything).Return(driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusPreconditionFailed,
+						ErrorNum: driver.ErrArangoConflict,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Client{
+					ID: "client-id",
+				},
+				rev: "rev-1",
+			},
+			wantErr:   true,
+			wantErrIs: ErrRevisionMismatch,
+		},
+		{
+			name: "update client not found",
+			fields: fields{
+				db: func(info oauth2.ClientInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("ReplaceDocument", mock.Anything, info.GetID(), mock.Anything).Return(driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusNotFound,
+						ErrorNum: driver.ErrArangoDocumentNotFound,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Client{
+					ID: "client-id",
+				},
+			},
+			wantErr:   true,
+			wantErrIs: ErrClientNotFound,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.info),
+				collection: tt.fields.collection,
+			}
+			got, err := s.Update(tt.args.ctx, tt.args.info, tt.args.rev)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Update() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Update() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStore_Delete(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, key string) driver.Database
+		tokens     func(ctx context.Context, key string) ClientTokenRemover
+		collection string
+	}
+	type args struct {
+		ctx context.Context
+		key string
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "delete client",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("RemoveDocument", ctx, key).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+		},
+		{
+			name: "delete client not found",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("RemoveDocument", ctx, key).Return(driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusNotFound,
+						ErrorNum: driver.ErrArangoDocumentNotFound,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			wantErr:   true,
+			wantErrIs: ErrClientNotFound,
+		},
+		{
+			name: "delete client cascading to tokens",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("RemoveDocument", ctx, key).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				tokens: func(ctx context.Context, key string) ClientTokenRemover {
+					query := "FOR doc IN @@collection FILTER doc.client_id == @client_id REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"client_id":   key,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return &TokenStore{db: db, collection: DefaultTokenStoreCollection}
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+		},
+		{
+			name: "delete client not found cascading to tokens",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("RemoveDocument", ctx, key).Return(driver.DocumentMeta{}, driver.ArangoError{
+						HasError: true,
+						Code:     http.StatusNotFound,
+						ErrorNum: driver.ErrArangoDocumentNotFound,
+					})
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				tokens: func(ctx context.Context, key string) ClientTokenRemover {
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(cursor, nil).Once()
+
+					return &TokenStore{db: db, collection: DefaultTokenStoreCollection}
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			wantErr:   true,
+			wantErrIs: ErrClientNotFound,
+		},
+		{
+			name: "delete client with token removal error",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("RemoveDocument", ctx, key).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				tokens: func(ctx context.Context, key string) ClientTokenRemover {
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+					return &TokenStore{db: db, collection: DefaultTokenStoreCollection}
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.ctx, tt.args.key),
+				collection: tt.fields.collection,
+			}
+			if tt.fields.tokens != nil {
+				s.tokens = tt.fields.tokens(tt.args.ctx, tt.args.key)
+			}
+			err := s.Delete(tt.args.ctx, tt.args.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Delete() error = %v, wantErrIs %v", err, tt.wantErrIs)
+			}
+		})
+	}
+}
+
+func TestClientStore_Exists(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, key string) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx context.Context
+		key string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "client exists",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("DocumentExists", ctx, key).Return(true, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			want: true,
+		},
+		{
+			name: "client exists with collection error",
+			fields: fields{
+				db: func(ctx context.Context, key string) driver.Database {
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				key: "client-id",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.ctx, tt.args.key),
+				collection: tt.fields.collection,
+			}
+			got, err := s.Exists(tt.args.ctx, tt.args.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Exists() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Exists() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStore_List(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, cursor string, limit int, clients []oauth2.ClientInfo) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx    context.Context
+		cursor string
+		limit  int
+	}
+	tests := []struct {
+		name     string
+		fields   fields
+		args     args
+		want     []oauth2.ClientInfo
+		wantNext string
+		wantErr  bool
+	}{
+		{
+			name: "list clients",
+			fields: fields{
+				db: func(ctx context.Context, cursor string, limit int, clients []oauth2.ClientInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc._key > @cursor SORT doc._key LIMIT @limit RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultClientStoreCollection,
+						"cursor":      cursor,
+						"limit":       limit,
+					}
+
+					c := new(MockArangoCursor)
+					c.On("Close").Return(nil)
+					for _, client := range clients {
+						doc, err := new(ClientStore).newItem(client)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						c.On("HasMore").Return(true).Once()
+						c.On("ReadDocument", ctx, mock.Anything).Return(doc, driver.DocumentMeta{}, nil).Once()
+					}
+					c.On("HasMore").Return(false).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(c, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				cursor: "client-0",
+				limit:  2,
+			},
+			want: []oauth2.ClientInfo{
+				&models.Client{ID: "client-1", Domain: "example.com"},
+				&models.Client{ID: "client-2", Domain: "example.com"},
+			},
+			wantNext: "client-2",
+		},
+		{
+			name: "list clients last page",
+			fields: fields{
+				db: func(ctx context.Context, cursor string, limit int, clients []oauth2.ClientInfo) driver.Database {
+					c := new(MockArangoCursor)
+					c.On("Close").Return(nil)
+					for _, client := range clients {
+						doc, err := new(ClientStore).newItem(client)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						c.On("HasMore").Return(true).Once()
+						c.On("ReadDocument", ctx, mock.Anything).Return(doc, driver.DocumentMeta{}, nil).Once()
+					}
+					c.On("HasMore").Return(false).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(c, nil)
+
+					return db
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				cursor: "client-2",
+				limit:  2,
+			},
+			want: []oauth2.ClientInfo{
+				&models.Client{ID: "client-3", Domain: "example.com"},
+			},
+		},
+		{
+			name: "list clients with invalid limit",
+			fields: fields{
+				db: func(ctx context.Context, cursor string, limit int, clients []oauth2.ClientInfo) driver.Database {
+					return new(MockArangoDB)
+				},
+				collection: DefaultClientStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.ctx, tt.args.cursor, tt.args.limit, tt.want),
+				collection: tt.fields.collection,
+			}
+			got, next, err := s.List(tt.args.ctx, tt.args.cursor, tt.args.limit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("List() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() got = %v, want %v", got, tt.want)
+			}
+			if next != tt.wantNext {
+				t.Errorf("List() next = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestHashedClient_VerifyPassword(t *testing.T) {
+	hasher := NewBcryptSecretHasher(4)
+
+	hash, err := hasher.Hash("client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		db     func() driver.Database
+		secret string
+	}
+	type args struct {
+		secret string
+	}
+	tests := []struct {
+		name       string
+		fields     fields
+		args       args
+		want       bool
+		wantHashed bool
+	}{
+		{
+			name: "verify hashed secret",
+			fields: fields{
+				db: func() driver.Database {
+					return new(MockArangoDB)
+				},
+				secret: hash,
+			},
+			args: args{
+				secret: "client-secret",
+			},
+			want:       true,
+			wantHashed: true,
+		},
+		{
+			name: "verify hashed secret mismatch",
+			fields: fields{
+				db: func() driver.Database {
+					return new(MockArangoDB)
+				},
+				secret: hash,
+			},
+			args: args{
+				secret: "other-secret",
+			},
+			wantHashed: true,
+		},
+		{
+			name: "verify plaintext secret upgrades secret",
+			fields: fields{
+				db: func() driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("ReplaceDocument", mock.Anything, "client-id", mock.MatchedBy(func(doc *ClientStoreItem) bool {
+						return hasher.IsHash(doc.Secret)
+					})).Return(driver.DocumentMeta{Rev: "rev-2"}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultClientStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				secret: "client-secret",
+			},
+			args: args{
+				secret: "client-secret",
+			},
+			want: true,
+		},
+		{
+			name: "verify empty secret of client without secret",
+			fields: fields{
+				db: func() driver.Database {
+					return new(MockArangoDB)
+				},
+			},
+			args: args{
+				secret: "",
+			},
+			want: true,
+		},
+		{
+			name: "verify secret of client without secret",
+			fields: fields{
+				db: func() driver.Database {
+					return new(MockArangoDB)
+				},
+			},
+			args: args{
+				secret: "client-secret",
+			},
+		},
+		{
+			name: "verify plaintext secret mismatch",
+			fields: fields{
+				db: func() driver.Database {
+					return new(MockArangoDB)
+				},
+				secret: "client-secret",
+			},
+			args: args{
+				secret: "other-secret",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &HashedClient{
+				Client: models.Client{
+					ID:     "client-id",
+					Secret: tt.fields.secret,
+				},
+				store: &ClientStore{
+					db:         tt.fields.db(),
+					collection: DefaultClientStoreCollection,
+					hasher:     hasher,
+				},
+				rev: "rev-1",
+			}
+			if got := c.VerifyPassword(tt.args.secret); got != tt.want {
+				t.Errorf("VerifyPassword() got = %v, want %v", got, tt.want)
+			}
+			if got := hasher.IsHash(c.GetSecret()); got != tt.wantHashed {
+				t.Errorf("VerifyPassword() hashed = %v, want %v", got, tt.wantHashed)
+			}
+		})
+	}
+}
+
+func TestHashedClient_VerifyPassword_Concurrent(t *testing.T) {
+	hasher := NewBcryptSecretHasher(4)
+
+	coll := new(MockArangoCollection)
+	coll.On("ReplaceDocument", mock.Anything, "client-id", mock.Anything).Return(driver.DocumentMeta{Rev: "rev-2"}, nil)
+
+	db := new(MockArangoDB)
+	db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil)
+
+	c := &HashedClient{
+		Client: models.Client{
+			ID:     "client-id",
+			Secret: "client-secret",
+		},
+		store: &ClientStore{
+			db:         db,
+			collection: DefaultClientStoreCollection,
+			hasher:     hasher,
+			cache:      newLRUCache[oauth2.ClientInfo](10, time.Minute, 0),
+		},
+		rev: "rev-1",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !c.VerifyPassword("client-secret") {
+				t.Error("VerifyPassword() got = false, want true")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.GetSecret() != "client-secret" || c.rev != "rev-1" {
+		t.Errorf("VerifyPassword() modified the client: secret = %v, rev = %v", c.GetSecret(), c.rev)
+	}
+}
+
+func TestClientStore_GetByID_Cache(t *testing.T) {
+	ctx := context.Background()
+
+	client := &models.Client{ID: "client-id", Secret: "client-secret", Domain: "example.com"}
+
+	doc, err := new(ClientStore).newItem(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll := new(MockArangoCollection)
+	coll.On("ReadDocument", ctx, "client-id", mock.Anything).Return(doc, driver.DocumentMeta{}, nil).Twice()
+	coll.On("ReadDocument", ctx, "unknown-id", mock.Anything).Return(nil, driver.DocumentMeta{}, driver.ArangoError{
+		HasError: true,
+		Code:     http.StatusNotFound,
+		ErrorNum: driver.ErrArangoDocumentNotFound,
+	}).Once()
+	coll.On("RemoveDocument", ctx, "client-id").Return(driver.DocumentMeta{}, nil)
+
+	db := new(MockArangoDB)
+	db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+
+	s, err := NewClientStore(
+		WithClientStoreDatabase(db),
+		WithClientStoreCache(10, time.Minute, time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := s.GetByID(ctx, "client-id")
+		if err != nil {
+			t.Fatalf("GetByID() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, client) {
+			t.Errorf("GetByID() got = %v, want %v", got, client)
+		}
+
+		if _, err := s.GetByID(ctx, "unknown-id"); !errors.Is(err, ErrClientNotFound) {
+			t.Errorf("GetByID() error = %v, wantErrIs %v", err, ErrClientNotFound)
+		}
+	}
+
+	// Deleting the client invalidates the cache, so it is read again.
+	if err := s.Delete(ctx, "client-id"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByID(ctx, "client-id"); err != nil {
+		t.Fatalf("GetByID() error = %v", err)
+	}
+
+	coll.AssertExpectations(t)
+}
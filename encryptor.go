@@ -0,0 +1,279 @@
+package arangostore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// Encryptor encrypts the data of the documents before they are stored.
+//
+// Every document stores the ID of the key its data was encrypted with, so
+// new keys can be introduced without downtime: the new key is used for new
+// documents, while the old keys remain available to decrypt existing ones
+// until they are re-encrypted.
+type Encryptor interface {
+	// KeyID returns the ID of the key used to encrypt new data.
+	KeyID() string
+	// Encrypt encrypts the plaintext and returns the ID of the key used.
+	Encrypt(plaintext []byte) (keyID string, ciphertext []byte, err error)
+	// Decrypt decrypts the ciphertext using the key with the given ID.
+	Decrypt(keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// AESGCMEncryptor is an Encryptor using envelope encryption with AES-GCM.
+// Every plaintext is encrypted with a random data key, which is encrypted by
+// the key encryption key and stored along with the ciphertext.
+type AESGCMEncryptor struct {
+	keyID string
+	keys  map[string]cipher.AEAD
+}
+
+const dataKeySize = 32
+
+// NewAESGCMEncryptor creates a new AESGCMEncryptor. The keys map key IDs to
+// AES-128, AES-192 or AES-256 key encryption keys. New data is encrypted
+// using the key identified by keyID, while all keys can decrypt data.
+func NewAESGCMEncryptor(keyID string, keys map[string][]byte) (*AESGCMEncryptor, error) {
+	if _, ok := keys[keyID]; !ok {
+		return nil, ErrUnknownKey
+	}
+
+	e := &AESGCMEncryptor{
+		keyID: keyID,
+		keys:  make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, key := range keys {
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, err
+		}
+
+		e.keys[id] = aead
+	}
+
+	return e, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext, prefixing the result with the random nonce.
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a ciphertext produced by seal.
+func open(aead cipher.AEAD, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
+
+// KeyID returns the ID of the key used to encrypt new data.
+func (e *AESGCMEncryptor) KeyID() string {
+	return e.keyID
+}
+
+// Encrypt encrypts the plaintext using a random data key.
+func (e *AESGCMEncryptor) Encrypt(plaintext []byte) (string, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", nil, err
+	}
+
+	wrappedKey, err := seal(e.keys[e.keyID], dataKey, []byte(e.keyID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ciphertext, err := seal(aead, plaintext, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return e.keyID, append(wrappedKey, ciphertext...), nil
+}
+
+// Decrypt decrypts the ciphertext using the key with the given ID.
+func (e *AESGCMEncryptor) Decrypt(keyID string, ciphertext []byte) ([]byte, error) {
+	kek, ok := e.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	wrappedKeySize := kek.NonceSize() + dataKeySize + kek.Overhead()
+	if len(ciphertext) < wrappedKeySize {
+		return nil, ErrInvalidCiphertext
+	}
+
+	dataKey, err := open(kek, ciphertext[:wrappedKeySize], []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, ciphertext[wrappedKeySize:], nil)
+}
+
+// encryptData encrypts the data if an encryptor is given. The returned key ID
+// is empty if the data is not encrypted.
+func encryptData(enc Encryptor, data []byte) (string, []byte, error) {
+	if enc == nil {
+		return "", data, nil
+	}
+
+	return enc.Encrypt(data)
+}
+
+// decryptData decrypts the data if it was encrypted. Data stored without a
+// key ID was stored before encryption was enabled and is returned as is.
+func decryptData(enc Encryptor, keyID string, data []byte) ([]byte, error) {
+	if keyID == "" {
+		return data, nil
+	}
+
+	if enc == nil {
+		return nil, ErrNoEncryptor
+	}
+
+	return enc.Decrypt(keyID, data)
+}
+
+// encryptedItem holds the fields of a document that are affected by the
+// encryption.
+type encryptedItem struct {
+	Key   string          `json:"_key"`
+	KeyID string          `json:"key_id"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// reencrypt encrypts the data of every document in the collection that is not
+// encrypted using the current key of the encryptor. The fields of patch are
+// set on every re-encrypted document. It returns the number of re-encrypted
+// documents.
+func reencrypt(ctx context.Context, db arangoDriver.Database, collection string, enc Encryptor, patch map[string]any) (count int, err error) {
+	coll, err := db.Collection(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "FOR doc IN @@collection FILTER doc.key_id != @key_id RETURN doc"
+	bindVars := map[string]any{
+		"@collection": collection,
+		"key_id":      enc.KeyID(),
+	}
+
+	cursor, err := db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer closeCursor(cursor, &err)
+
+	for cursor.HasMore() {
+		var doc encryptedItem
+		meta, err := cursor.ReadDocument(ctx, &doc)
+		if err != nil {
+			return count, err
+		}
+
+		reencrypted, err := reencryptDocument(ctx, coll, enc, doc, meta.Rev, patch)
+		if err != nil {
+			return count, err
+		}
+
+		if reencrypted {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// reencryptDocument encrypts the data of the document read at the given
+// revision using the current key of the encryptor. If the document was changed
+// since it was read, it is read again and re-encrypted, unless it was removed
+// or re-encrypted in the meantime. It reports whether the document was
+// re-encrypted.
+func reencryptDocument(ctx context.Context, coll arangoDriver.Collection, enc Encryptor, doc encryptedItem, rev string, patch map[string]any) (bool, error) {
+	for {
+		data, err := decodeData(doc.Data)
+		if err != nil {
+			return false, err
+		}
+
+		plaintext, err := decryptData(enc, doc.KeyID, data)
+		if err != nil {
+			return false, err
+		}
+
+		keyID, ciphertext, err := enc.Encrypt(plaintext)
+		if err != nil {
+			return false, err
+		}
+
+		update := map[string]any{
+			"key_id": keyID,
+			"data":   ciphertext,
+		}
+		for field, value := range patch {
+			update[field] = value
+		}
+
+		_, err = coll.UpdateDocument(arangoDriver.WithRevision(ctx, rev), doc.Key, update)
+		if err == nil {
+			return true, nil
+		}
+
+		if !arangoDriver.IsPreconditionFailed(err) {
+			return false, err
+		}
+
+		// The document may have been changed for another reason than being
+		// re-encrypted, hence it is checked again.
+		var current encryptedItem
+		meta, err := coll.ReadDocument(ctx, doc.Key, &current)
+		if err != nil {
+			if arangoDriver.IsNotFoundGeneral(err) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		if current.KeyID == enc.KeyID() {
+			return false, nil
+		}
+
+		doc, rev = current, meta.Rev
+	}
+}
@@ -0,0 +1,538 @@
+package arangostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+)
+
+var (
+	testKeyV1 = bytes.Repeat([]byte{1}, 32)
+	testKeyV2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestNewAESGCMEncryptor(t *testing.T) {
+	type args struct {
+		keyID string
+		keys  map[string][]byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "new encryptor",
+			args: args{
+				keyID: "v2",
+				keys:  map[string][]byte{"v1": testKeyV1, "v2": testKeyV2},
+			},
+		},
+		{
+			name: "new encryptor with unknown key",
+			args: args{
+				keyID: "v3",
+				keys:  map[string][]byte{"v1": testKeyV1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new encryptor with invalid key",
+			args: args{
+				keyID: "v1",
+				keys:  map[string][]byte{"v1": []byte("short")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := NewAESGCMEncryptor(tt.args.keyID, tt.args.keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewAESGCMEncryptor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.KeyID() != tt.args.keyID {
+				t.Errorf("NewAESGCMEncryptor() key id = %v, want %v", got.KeyID(), tt.args.keyID)
+			}
+		})
+	}
+}
+
+func TestAESGCMEncryptor_Decrypt(t *testing.T) {
+	old, err := NewAESGCMEncryptor("v1", map[string][]byte{"v1": testKeyV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := NewAESGCMEncryptor("v2", map[string][]byte{"v1": testKeyV1, "v2": testKeyV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"ClientID":"client-id"}`)
+
+	keyID, ciphertext, err := old.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+
+	type args struct {
+		keyID      string
+		ciphertext []byte
+	}
+	tests := []struct {
+		name    string
+		enc     Encryptor
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "decrypt with same key",
+			enc:  old,
+			args: args{
+				keyID:      keyID,
+				ciphertext: ciphertext,
+			},
+			want: plaintext,
+		},
+		{
+			name: "decrypt after key rotation",
+			enc:  rotated,
+			args: args{
+				keyID:      keyID,
+				ciphertext: ciphertext,
+			},
+			want: plaintext,
+		},
+		{
+			name: "decrypt with unknown key",
+			enc:  old,
+			args: args{
+				keyID:      "v2",
+				ciphertext: ciphertext,
+			},
+			wantErr: true,
+		},
+		{
+			name: "decrypt tampered ciphertext",
+			enc:  old,
+			args: args{
+				keyID:      keyID,
+				ciphertext: tampered,
+			},
+			wantErr: true,
+		},
+		{
+			name: "decrypt truncated ciphertext",
+			enc:  old,
+			args: args{
+				keyID:      keyID,
+				ciphertext: ciphertext[:10],
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := tt.enc.Decrypt(tt.args.keyID, tt.args.ciphertext)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Decrypt() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStore_Reencrypt(t *testing.T) {
+	enc, err := NewAESGCMEncryptor("v2", map[string][]byte{"v1": testKeyV1, "v2": testKeyV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		db        func(ctx context.Context) driver.Database
+		encryptor Encryptor
+	}
+	type args struct {
+		ctx context.Context
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		want      int
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "reencrypt plaintext client",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.key_id != @key_id RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultClientStoreCollection,
+						"key_id":      "v2",
+					}
+
+					doc, err := new(ClientStore).newItem(&models.Client{ID: "client-id", Secret: "client-secret"})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					plaintext, err := decodeData(doc.Data)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(doc, driver.DocumentMeta{Rev: "rev-1"}, nil)
+
+					coll := new(MockArangoCollection)
+					coll.On("UpdateDocument", mock.Anything, "client-id", mock.MatchedBy(func(update map[string]any) bool {
+						data, err := enc.Decrypt(update["key_id"].(string), update["data"].([]byte))
+						return err == nil && bytes.Equal(data, plaintext) && update["secret"] == ""
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(coll, nil)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				encryptor: enc,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: 1,
+		},
+		{
+			name: "reencrypt with query error",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultClientStoreCollection).Return(new(MockArangoCollection), nil)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				encryptor: enc,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "reencrypt without encryptor",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					return new(MockArangoDB)
+				},
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr:   true,
+			wantErrIs: ErrNoEncryptor,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.fields.db(tt.args.ctx),
+				collection: DefaultClientStoreCollection,
+				encryptor:  tt.fields.encryptor,
+			}
+			got, err := s.Reencrypt(tt.args.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reencrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Reencrypt() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Reencrypt() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_Reencrypt(t *testing.T) {
+	enc, err := NewAESGCMEncryptor("v2", map[string][]byte{"v1": testKeyV1, "v2": testKeyV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldEnc, err := NewAESGCMEncryptor("v1", map[string][]byte{"v1": testKeyV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte(`{"Access":"test-access-token"}`)
+
+	// encryptedDoc returns the document holding the plaintext encrypted using
+	// the given encryptor.
+	encryptedDoc := func(enc Encryptor) *encryptedItem {
+		keyID, ciphertext, err := enc.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := encodeData(ciphertext, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &encryptedItem{Key: "token-key", KeyID: keyID, Data: data}
+	}
+
+	reencrypted := mock.MatchedBy(func(update map[string]any) bool {
+		data, err := enc.Decrypt(update["key_id"].(string), update["data"].([]byte))
+		return err == nil && bytes.Equal(data, plaintext) && update["key_id"] == "v2" && len(update) == 2
+	})
+
+	preconditionFailed := driver.ArangoError{
+		HasError: true,
+		Code:     http.StatusPreconditionFailed,
+		ErrorNum: driver.ErrArangoConflict,
+	}
+
+	tests := []struct {
+		name      string
+		coll      func(ctx context.Context) *MockArangoCollection
+		encryptor Encryptor
+		want      int
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "reencrypt token encrypted using previous key",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				coll := new(MockArangoCollection)
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, nil).Once()
+
+				return coll
+			},
+			encryptor: enc,
+			want:      1,
+		},
+		{
+			name: "reencrypt token changed in the meantime",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				coll := new(MockArangoCollection)
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, preconditionFailed).Once()
+				coll.On("ReadDocument", ctx, "token-key", mock.Anything).Return(encryptedDoc(oldEnc), driver.DocumentMeta{Rev: "rev-2"}, nil).Once()
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, nil).Once()
+
+				return coll
+			},
+			encryptor: enc,
+			want:      1,
+		},
+		{
+			name: "skip token reencrypted in the meantime",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				coll := new(MockArangoCollection)
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, preconditionFailed).Once()
+				coll.On("ReadDocument", ctx, "token-key", mock.Anything).Return(encryptedDoc(enc), driver.DocumentMeta{Rev: "rev-2"}, nil).Once()
+
+				return coll
+			},
+			encryptor: enc,
+		},
+		{
+			name: "skip token removed in the meantime",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				coll := new(MockArangoCollection)
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, preconditionFailed).Once()
+				coll.On("ReadDocument", ctx, "token-key", mock.Anything).Return(nil, driver.DocumentMeta{}, driver.ArangoError{
+					HasError: true,
+					Code:     http.StatusNotFound,
+					ErrorNum: driver.ErrArangoDocumentNotFound,
+				}).Once()
+
+				return coll
+			},
+			encryptor: enc,
+		},
+		{
+			name: "reencrypt token with update error",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				coll := new(MockArangoCollection)
+				coll.On("UpdateDocument", mock.Anything, "token-key", reencrypted).Return(driver.DocumentMeta{}, fmt.Errorf("error")).Once()
+
+				return coll
+			},
+			encryptor: enc,
+			wantErr:   true,
+		},
+		{
+			name: "reencrypt without encryptor",
+			coll: func(ctx context.Context) *MockArangoCollection {
+				return nil
+			},
+			wantErr:   true,
+			wantErrIs: ErrNoEncryptor,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			db := new(MockArangoDB)
+			coll := tt.coll(ctx)
+			if coll != nil {
+				cursor := new(MockArangoCursor)
+				cursor.On("Close").Return(nil)
+				cursor.On("HasMore").Return(true).Once()
+				cursor.On("HasMore").Return(false).Once()
+				cursor.On("ReadDocument", ctx, mock.Anything).Return(encryptedDoc(oldEnc), driver.DocumentMeta{Rev: "rev-1"}, nil)
+
+				db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+				db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.key_id != @key_id RETURN doc", map[string]any{
+					"@collection": DefaultTokenStoreCollection,
+					"key_id":      "v2",
+				}).Return(cursor, nil)
+			}
+
+			s := &TokenStore{
+				db:         db,
+				collection: DefaultTokenStoreCollection,
+				encryptor:  tt.encryptor,
+			}
+			got, err := s.Reencrypt(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Reencrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Reencrypt() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Reencrypt() got = %v, want %v", got, tt.want)
+			}
+			if coll != nil {
+				coll.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestTokenStore_Encryption(t *testing.T) {
+	ctx := context.Background()
+	db := arangotest.NewDatabase("test")
+
+	newStore := func(enc Encryptor) *TokenStore {
+		s, err := NewTokenStore(
+			WithTokenStoreDatabase(db),
+			WithTokenStoreEnsureSchema(nil),
+			WithTokenStoreEncryptor(enc),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	oldEnc, err := NewAESGCMEncryptor("v1", map[string][]byte{"v1": testKeyV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := NewAESGCMEncryptor("v2", map[string][]byte{"v1": testKeyV1, "v2": testKeyV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &models.Token{ClientID: "client-id", UserID: "user-id", Access: "access"}
+	if err := newStore(oldEnc).Create(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newStore(enc)
+
+	// The tokens encrypted using the previous key are still readable.
+	if got, err := s.GetByAccess(ctx, "access"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GetByAccess() = %v, %v, want %v", got, err, want)
+	}
+
+	if got, err := s.Reencrypt(ctx); err != nil || got != 1 {
+		t.Errorf("Reencrypt() = %v, %v, want %v", got, err, 1)
+	}
+
+	if got, err := s.Reencrypt(ctx); err != nil || got != 0 {
+		t.Errorf("Reencrypt() = %v, %v, want %v", got, err, 0)
+	}
+
+	// The re-encrypted tokens are no longer readable using the previous key.
+	if _, err := newStore(oldEnc).GetByAccess(ctx, "access"); err == nil {
+		t.Error("GetByAccess() error = nil, want error")
+	}
+
+	if got, err := s.GetByAccess(ctx, "access"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("GetByAccess() = %v, %v, want %v", got, err, want)
+	}
+}
+
+func TestClientStore_decodeClient(t *testing.T) {
+	enc, err := NewAESGCMEncryptor("v1", map[string][]byte{"v1": testKeyV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &models.Client{ID: "client-id", Secret: "client-secret", Domain: "example.com"}
+
+	s := &ClientStore{encryptor: enc}
+
+	doc, err := s.newItem(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.KeyID != "v1" || doc.Secret != "" || bytes.Contains(doc.Data, []byte("client-secret")) {
+		t.Fatalf("newItem() got = %v, want encrypted data", doc)
+	}
+
+	got, err := s.decodeClient(doc, driver.DocumentMeta{})
+	if err != nil {
+		t.Fatalf("decodeClient() error = %v", err)
+	}
+
+	if *got.(*models.Client) != *want {
+		t.Errorf("decodeClient() got = %v, want %v", got, want)
+	}
+}
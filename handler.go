@@ -0,0 +1,200 @@
+package arangostore
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+const (
+	tokenTypeHintAccessToken  = "access_token"
+	tokenTypeHintRefreshToken = "refresh_token"
+)
+
+// errorResponse is the error response of the endpoints as described in
+// RFC 6749, section 5.2.
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// writeJSON writes the response as JSON, making sure it is not cached.
+func writeJSON(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// writeError writes an error response with the given error code.
+func writeError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, &errorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// writeInvalidClient writes the error response of a failed client
+// authentication. The client is challenged to authenticate using HTTP Basic
+// authentication if it tried to do so.
+func writeInvalidClient(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+
+	writeError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
+
+// parseTokenRequest checks the method of the request and parses its body. It
+// writes an error response and returns false if the request is invalid.
+func parseTokenRequest(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "method not allowed")
+
+		return false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid request body")
+		return false
+	}
+
+	return true
+}
+
+// errInvalidClient is returned by authenticateClient if the client cannot be
+// authenticated.
+var errInvalidClient = errors.New("invalid client")
+
+// clientCredentials returns the credentials of the client, presented either
+// using HTTP Basic authentication or in the request body, as described in
+// RFC 6749, section 2.3.1.
+func clientCredentials(r *http.Request) (id string, secret string, err error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), nil
+	}
+
+	// Clients must not use more than one authentication method.
+	if r.PostForm.Has("client_id") || r.PostForm.Has("client_secret") {
+		return "", "", errInvalidClient
+	}
+
+	if id, err = url.QueryUnescape(id); err != nil {
+		return "", "", errInvalidClient
+	}
+
+	if secret, err = url.QueryUnescape(secret); err != nil {
+		return "", "", errInvalidClient
+	}
+
+	return id, secret, nil
+}
+
+// authenticateClient returns the client making the request. Confidential
+// clients must present their secret, while public clients are identified by
+// their ID only.
+func authenticateClient(ctx context.Context, clients oauth2.ClientStore, r *http.Request) (oauth2.ClientInfo, error) {
+	id, secret, err := clientCredentials(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := clients.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrClientNotFound) {
+			return nil, errInvalidClient
+		}
+
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, errInvalidClient
+	}
+
+	if client.IsPublic() {
+		return client, nil
+	}
+
+	if secret == "" {
+		return nil, errInvalidClient
+	}
+
+	if verifier, ok := client.(oauth2.ClientPasswordVerifier); ok {
+		if !verifier.VerifyPassword(secret) {
+			return nil, errInvalidClient
+		}
+
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(secret)) != 1 {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+// RefreshTokenFinder looks up tokens by their refresh token without the side
+// effects of GetByRefresh, like the revocation of a token family when a
+// rotated refresh token is presented. It is implemented by TokenStore and
+// CachedTokenStore.
+type RefreshTokenFinder interface {
+	FindByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error)
+}
+
+// findByRefresh returns the token by its refresh token, using FindByRefresh if
+// the store implements it.
+func findByRefresh(ctx context.Context, tokens oauth2.TokenStore, refresh string) (oauth2.TokenInfo, error) {
+	if finder, ok := tokens.(RefreshTokenFinder); ok {
+		return finder.FindByRefresh(ctx, refresh)
+	}
+
+	return tokens.GetByRefresh(ctx, refresh)
+}
+
+// lookupToken returns the token and its type, looking it up as the token type
+// given by the hint first. The token is looked up as every other supported
+// type if it is not found using the hint. A nil token is returned if the token
+// does not exist. A refresh token that was already rotated is not found.
+func lookupToken(ctx context.Context, tokens oauth2.TokenStore, token string, hint string) (oauth2.TokenInfo, string, error) {
+	lookups := []string{tokenTypeHintAccessToken, tokenTypeHintRefreshToken}
+	if hint == tokenTypeHintRefreshToken {
+		lookups = []string{tokenTypeHintRefreshToken, tokenTypeHintAccessToken}
+	}
+
+	for _, tokenType := range lookups {
+		var info oauth2.TokenInfo
+		var err error
+
+		switch tokenType {
+		case tokenTypeHintAccessToken:
+			info, err = tokens.GetByAccess(ctx, token)
+		case tokenTypeHintRefreshToken:
+			info, err = findByRefresh(ctx, tokens, token)
+		}
+
+		if err != nil && !errors.Is(err, ErrTokenNotFound) && !errors.Is(err, ErrRefreshTokenReuse) {
+			return nil, "", err
+		}
+
+		if err == nil && info != nil {
+			return info, tokenType, nil
+		}
+	}
+
+	return nil, "", nil
+}
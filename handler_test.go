@@ -0,0 +1,320 @@
+package arangostore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+)
+
+// fakeClientStore is an oauth2.ClientStore holding the clients in memory.
+type fakeClientStore map[string]oauth2.ClientInfo
+
+func (s fakeClientStore) GetByID(_ context.Context, id string) (oauth2.ClientInfo, error) {
+	if id == "broken" {
+		return nil, fmt.Errorf("error")
+	}
+
+	client, ok := s[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+// fakeTokenStore is an oauth2.TokenStore holding the tokens in memory.
+type fakeTokenStore struct {
+	tokens []oauth2.TokenInfo
+}
+
+func (s *fakeTokenStore) Create(_ context.Context, info oauth2.TokenInfo) error {
+	s.tokens = append(s.tokens, info)
+	return nil
+}
+
+func (s *fakeTokenStore) get(match func(info oauth2.TokenInfo) bool) (oauth2.TokenInfo, error) {
+	for _, info := range s.tokens {
+		if match(info) {
+			return info, nil
+		}
+	}
+
+	return nil, ErrTokenNotFound
+}
+
+func (s *fakeTokenStore) remove(match func(info oauth2.TokenInfo) bool) error {
+	tokens := s.tokens[:0]
+	for _, info := range s.tokens {
+		if !match(info) {
+			tokens = append(tokens, info)
+		}
+	}
+
+	s.tokens = tokens
+
+	return nil
+}
+
+func (s *fakeTokenStore) RemoveByCode(_ context.Context, code string) error {
+	return s.remove(func(info oauth2.TokenInfo) bool { return info.GetCode() == code })
+}
+
+func (s *fakeTokenStore) RemoveByAccess(_ context.Context, access string) error {
+	return s.remove(func(info oauth2.TokenInfo) bool { return info.GetAccess() == access })
+}
+
+func (s *fakeTokenStore) RemoveByRefresh(_ context.Context, refresh string) error {
+	return s.remove(func(info oauth2.TokenInfo) bool { return info.GetRefresh() == refresh })
+}
+
+func (s *fakeTokenStore) RemoveByUser(_ context.Context, userID string) error {
+	return s.remove(func(info oauth2.TokenInfo) bool { return info.GetUserID() == userID })
+}
+
+func (s *fakeTokenStore) RemoveByClient(_ context.Context, clientID string) error {
+	return s.remove(func(info oauth2.TokenInfo) bool { return info.GetClientID() == clientID })
+}
+
+func (s *fakeTokenStore) GetByCode(_ context.Context, code string) (oauth2.TokenInfo, error) {
+	return s.get(func(info oauth2.TokenInfo) bool { return info.GetCode() == code })
+}
+
+func (s *fakeTokenStore) GetByAccess(_ context.Context, access string) (oauth2.TokenInfo, error) {
+	return s.get(func(info oauth2.TokenInfo) bool { return info.GetAccess() == access })
+}
+
+func (s *fakeTokenStore) GetByRefresh(_ context.Context, refresh string) (oauth2.TokenInfo, error) {
+	return s.get(func(info oauth2.TokenInfo) bool { return info.GetRefresh() == refresh })
+}
+
+var testClients = fakeClientStore{
+	"client-id": &models.Client{ID: "client-id", Secret: "client-secret"},
+	"other-id":  &models.Client{ID: "other-id", Secret: "other-secret"},
+	"public-id": &models.Client{ID: "public-id", Public: true},
+	"hashed-id": &HashedClient{
+		Client: models.Client{ID: "hashed-id", Secret: "$2a$04$Xhqpd9OR2GbRhs0cyZx71O3gp5ydlW.YIdY79qjF06DrJCrGDcfIm"},
+		store:  &ClientStore{hasher: NewBcryptSecretHasher(4)},
+	},
+}
+
+// newRotatedTokenStore returns a token store rotating refresh tokens, holding
+// a token of client-id whose refresh token was rotated from the returned one.
+func newRotatedTokenStore(t *testing.T) (*TokenStore, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	s, err := NewTokenStore(
+		WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+		WithTokenStoreEnsureSchema(nil),
+		WithTokenStoreRefreshRotation(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Create(ctx, &models.Token{
+		ClientID:         "client-id",
+		Access:           "access-token",
+		AccessCreateAt:   time.Now(),
+		AccessExpiresIn:  time.Hour,
+		Refresh:          "refresh-token",
+		RefreshCreateAt:  time.Now(),
+		RefreshExpiresIn: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.GetByRefresh(ctx, "refresh-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.SetAccess("new-access-token")
+	info.SetRefresh("new-refresh-token")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manager removes the rotated refresh token after the refresh.
+	if err := s.RemoveByRefresh(ctx, "refresh-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, "refresh-token"
+}
+
+func newFormRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "authenticate client using basic auth",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			want: "client-id",
+		},
+		{
+			name: "authenticate client using basic auth with encoded credentials",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{})
+				r.SetBasicAuth(url.QueryEscape("client-id"), url.QueryEscape("client-secret"))
+
+				return r
+			},
+			want: "client-id",
+		},
+		{
+			name: "authenticate client using request body",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"client-id"}, "client_secret": {"client-secret"}})
+			},
+			want: "client-id",
+		},
+		{
+			name: "authenticate public client",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"public-id"}})
+			},
+			want: "public-id",
+		},
+		{
+			name: "authenticate client with hashed secret",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"hashed-id"}, "client_secret": {"client-secret"}})
+			},
+			want: "hashed-id",
+		},
+		{
+			name: "authenticate client with invalid hashed secret",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"hashed-id"}, "client_secret": {"invalid"}})
+			},
+			wantErr: true,
+		},
+		{
+			name: "authenticate client with invalid secret",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{})
+				r.SetBasicAuth("client-id", "invalid")
+
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "authenticate client without secret",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"client-id"}})
+			},
+			wantErr: true,
+		},
+		{
+			name: "authenticate unknown client",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"client_id": {"unknown-id"}, "client_secret": {"client-secret"}})
+			},
+			wantErr: true,
+		},
+		{
+			name: "authenticate client using multiple methods",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"client_id": {"client-id"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			wantErr: true,
+		},
+		{
+			name: "authenticate without credentials",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{})
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := tt.request()
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := authenticateClient(r.Context(), testClients, r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authenticateClient() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.GetID() != tt.want {
+				t.Errorf("authenticateClient() got = %v, want %v", got.GetID(), tt.want)
+			}
+		})
+	}
+}
+
+// decodeErrorResponse returns the error code of the response, or an empty
+// string if the response is not an error.
+func decodeErrorResponse(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if w.Body.Len() == 0 {
+		return ""
+	}
+
+	var response errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %v, want no-store", w.Header().Get("Cache-Control"))
+	}
+
+	return response.Error
+}
+
+// reusedTokenStore is a token store detecting the reuse of every refresh
+// token, without implementing RefreshTokenFinder.
+type reusedTokenStore struct {
+	fakeTokenStore
+}
+
+func (s *reusedTokenStore) GetByRefresh(context.Context, string) (oauth2.TokenInfo, error) {
+	return nil, ErrRefreshTokenReuse
+}
+
+func TestLookupToken_RefreshTokenReuse(t *testing.T) {
+	info, tokenType, err := lookupToken(context.Background(), new(reusedTokenStore), "refresh-token", "refresh_token")
+	if err != nil || info != nil || tokenType != "" {
+		t.Errorf("lookupToken() = %v, %v, %v, want not found", info, tokenType, err)
+	}
+}
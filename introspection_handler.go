@@ -0,0 +1,128 @@
+package arangostore
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// introspectionResponse is the response of the introspection endpoint as
+// described in RFC 7662, section 2.2.
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Subject  string `json:"sub,omitempty"`
+	Expiry   int64  `json:"exp,omitempty"`
+	IssuedAt int64  `json:"iat,omitempty"`
+}
+
+// IntrospectionHandler is an http.Handler implementing the token
+// introspection endpoint described in RFC 7662.
+//
+// The calling resource server is authenticated as a confidential client using
+// the client store, then the access or refresh token presented in the token
+// parameter is looked up in the token store. Unknown and expired tokens are
+// reported as inactive. Public clients are rejected, as RFC 7662, section 2.1
+// requires the caller to be authenticated.
+type IntrospectionHandler struct {
+	clients oauth2.ClientStore
+	tokens  oauth2.TokenStore
+}
+
+// NewIntrospectionHandler creates a new IntrospectionHandler using the given
+// stores.
+func NewIntrospectionHandler(clients oauth2.ClientStore, tokens oauth2.TokenStore) (*IntrospectionHandler, error) {
+	if clients == nil {
+		return nil, ErrNoClientStore
+	}
+
+	if tokens == nil {
+		return nil, ErrNoTokenStore
+	}
+
+	return &IntrospectionHandler{
+		clients: clients,
+		tokens:  tokens,
+	}, nil
+}
+
+// introspect returns the introspection response of the token having the given
+// type.
+func introspect(info oauth2.TokenInfo, tokenType string, now time.Time) *introspectionResponse {
+	createdAt, expiresIn := info.GetAccessCreateAt(), info.GetAccessExpiresIn()
+	if tokenType == tokenTypeHintRefreshToken {
+		createdAt, expiresIn = info.GetRefreshCreateAt(), info.GetRefreshExpiresIn()
+	}
+
+	response := &introspectionResponse{
+		Active:   true,
+		Scope:    info.GetScope(),
+		ClientID: info.GetClientID(),
+		Subject:  info.GetUserID(),
+	}
+
+	if !createdAt.IsZero() {
+		response.IssuedAt = createdAt.Unix()
+	}
+
+	// Tokens without expiry never expire.
+	if expiresIn > 0 {
+		expiresAt := createdAt.Add(expiresIn)
+		if !expiresAt.After(now) {
+			return &introspectionResponse{}
+		}
+
+		response.Expiry = expiresAt.Unix()
+	}
+
+	return response
+}
+
+// ServeHTTP implements http.Handler.
+func (h *IntrospectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !parseTokenRequest(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+
+	client, err := authenticateClient(ctx, h.clients, r)
+	if err != nil {
+		if errors.Is(err, errInvalidClient) {
+			writeInvalidClient(w, r)
+			return
+		}
+
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	// Public clients are identified by their ID only, which would let anyone
+	// knowing it scan for tokens.
+	if client.IsPublic() {
+		writeInvalidClient(w, r)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	info, tokenType, err := lookupToken(ctx, h.tokens, token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	if info == nil {
+		writeJSON(w, http.StatusOK, &introspectionResponse{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, introspect(info, tokenType, time.Now()))
+}
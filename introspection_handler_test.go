@@ -0,0 +1,213 @@
+package arangostore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+func TestIntrospectionHandler_ServeHTTP(t *testing.T) {
+	createdAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	tokens := []oauth2.TokenInfo{
+		&models.Token{
+			ClientID:         "client-id",
+			UserID:           "user-id",
+			Scope:            "read write",
+			Access:           "access-token",
+			AccessCreateAt:   createdAt,
+			AccessExpiresIn:  time.Hour,
+			Refresh:          "refresh-token",
+			RefreshCreateAt:  createdAt,
+			RefreshExpiresIn: 0,
+		},
+		&models.Token{
+			ClientID:        "client-id",
+			UserID:          "user-id",
+			Access:          "expired-access-token",
+			AccessCreateAt:  createdAt,
+			AccessExpiresIn: time.Second,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		request    func() *http.Request
+		wantStatus int
+		wantError  string
+		want       *introspectionResponse
+	}{
+		{
+			name: "introspect access token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"access-token"}})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			wantStatus: http.StatusOK,
+			want: &introspectionResponse{
+				Active:   true,
+				Scope:    "read write",
+				ClientID: "client-id",
+				Subject:  "user-id",
+				Expiry:   createdAt.Add(time.Hour).Unix(),
+				IssuedAt: createdAt.Unix(),
+			},
+		},
+		{
+			name: "introspect refresh token without expiry",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"refresh-token"}, "token_type_hint": {"refresh_token"}})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			wantStatus: http.StatusOK,
+			want: &introspectionResponse{
+				Active:   true,
+				Scope:    "read write",
+				ClientID: "client-id",
+				Subject:  "user-id",
+				IssuedAt: createdAt.Unix(),
+			},
+		},
+		{
+			name: "introspect expired token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"expired-access-token"}})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			wantStatus: http.StatusOK,
+			want:       &introspectionResponse{},
+		},
+		{
+			name: "introspect unknown token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"unknown-token"}})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			wantStatus: http.StatusOK,
+			want:       &introspectionResponse{},
+		},
+		{
+			name: "introspect token with invalid client",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"access-token"}})
+				r.SetBasicAuth("other-id", "invalid")
+
+				return r
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name: "introspect token with public client",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"token": {"access-token"}, "client_id": {"public-id"}})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name: "introspect without token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_request",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h, err := NewIntrospectionHandler(testClients, &fakeTokenStore{tokens: tokens})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.request())
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+
+			if tt.want == nil {
+				if got := decodeErrorResponse(t, w); got != tt.wantError {
+					t.Errorf("ServeHTTP() error = %v, want %v", got, tt.wantError)
+				}
+				return
+			}
+
+			got := new(introspectionResponse)
+			if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServeHTTP() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectionHandler_ServeHTTP_Inactive(t *testing.T) {
+	h, err := NewIntrospectionHandler(testClients, new(fakeTokenStore))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newFormRequest(url.Values{"token": {"unknown-token"}})
+	r.SetBasicAuth("client-id", "client-secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got, want := w.Body.String(), "{\"active\":false}\n"; got != want {
+		t.Errorf("ServeHTTP() body = %q, want %q", got, want)
+	}
+}
+
+func TestIntrospectionHandler_ServeHTTP_RotatedRefreshToken(t *testing.T) {
+	tokens, refresh := newRotatedTokenStore(t)
+
+	h, err := NewIntrospectionHandler(testClients, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newFormRequest(url.Values{"token": {refresh}, "token_type_hint": {"refresh_token"}})
+	r.SetBasicAuth("other-id", "other-secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	if got, want := w.Body.String(), "{\"active\":false}\n"; got != want {
+		t.Errorf("ServeHTTP() body = %q, want %q", got, want)
+	}
+
+	// Introspecting a rotated refresh token must not revoke its family.
+	if _, err := tokens.GetByAccess(context.Background(), "new-access-token"); err != nil {
+		t.Errorf("GetByAccess() error = %v", err)
+	}
+}
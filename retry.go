@@ -0,0 +1,115 @@
+package arangostore
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// RetryPolicy configures how the stores retry operations failing with a
+// transient error, like the errors returned by a cluster during a failover.
+//
+// Only operations that are safe to repeat are retried: lookups and removals,
+// as well as replacing a client without a revision. Creating tokens and
+// clients, consuming authorization codes and updating a client by revision
+// are never retried, as repeating them after an attempt that succeeded on the
+// server could create duplicates or report a conflict. Operations that are
+// part of a transaction are not retried either, the whole transaction must be
+// retried instead.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts made, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. The delay doubles
+	// with each retry and is randomized by up to half of its value.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff time.Duration
+	// Retryable reports whether an operation failing with the error can be
+	// retried. If nil, IsTransientError is used.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns a retry policy making up to three attempts,
+// waiting about 50ms before the first retry and at most one second between
+// two attempts.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+}
+
+// IsTransientError returns true if the error is returned by ArangoDB while
+// the service is unavailable temporarily, like while the leader of a shard
+// changes.
+func IsTransientError(err error) bool {
+	if !arangoDriver.IsArangoError(err) {
+		return false
+	}
+
+	return arangoDriver.IsArangoErrorWithCode(err, http.StatusServiceUnavailable) ||
+		arangoDriver.IsArangoErrorWithErrorNum(err, arangoDriver.ErrClusterNotLeader, arangoDriver.ErrClusterLeadershipChallengeOngoing)
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 || p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff {
+		return ErrInvalidRetryPolicy
+	}
+
+	return nil
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return IsTransientError(err)
+}
+
+// backoff returns the delay before the given retry, counted from zero.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 0; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	if jitter := int64(backoff / 2); jitter > 0 {
+		backoff -= time.Duration(rand.Int63n(jitter + 1)) // nolint: gosec
+	}
+
+	return backoff
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable or
+// the attempts are exhausted, and returns the error of the last attempt. A nil
+// policy calls fn once. If the context is done while waiting for the next
+// attempt, the error of the last attempt is returned.
+func (p *RetryPolicy) do(ctx context.Context, fn func() error) error {
+	if p == nil || ctx.Value(transactionKey{}) != nil {
+		return fn()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt - 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/mock"
+)
+
+var (
+	errUnavailable = driver.ArangoError{HasError: true, Code: http.StatusServiceUnavailable, ErrorMessage: "service unavailable"}
+	errNotLeader   = driver.ArangoError{HasError: true, Code: http.StatusServiceUnavailable, ErrorNum: driver.ErrClusterNotLeader, ErrorMessage: "not a leader"}
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "service unavailable",
+			err:  errUnavailable,
+			want: true,
+		},
+		{
+			name: "not a leader",
+			err:  errNotLeader,
+			want: true,
+		},
+		{
+			name: "leadership challenge ongoing",
+			err:  driver.ArangoError{HasError: true, Code: http.StatusInternalServerError, ErrorNum: driver.ErrClusterLeadershipChallengeOngoing},
+			want: true,
+		},
+		{
+			name: "document not found",
+			err:  driver.ArangoError{HasError: true, Code: http.StatusNotFound, ErrorNum: driver.ErrArangoDocumentNotFound},
+		},
+		{
+			name: "conflict",
+			err:  driver.ArangoError{HasError: true, Code: http.StatusConflict, ErrorNum: driver.ErrArangoUniqueConstraintViolated},
+		},
+		{
+			name: "deadline exceeded",
+			err:  context.DeadlineExceeded,
+		},
+		{
+			name: "nil error",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := IsTransientError(tt.err); got != tt.want {
+				t.Errorf("IsTransientError() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_do(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		ctx          context.Context
+		errs         []error
+		wantAttempts int
+		wantErrIs    error
+	}{
+		{
+			name:         "succeed",
+			policy:       policy,
+			ctx:          context.Background(),
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeed after transient errors",
+			policy:       policy,
+			ctx:          context.Background(),
+			errs:         []error{errUnavailable, errNotLeader},
+			wantAttempts: 3,
+		},
+		{
+			name:         "fail after max attempts",
+			policy:       policy,
+			ctx:          context.Background(),
+			errs:         []error{errUnavailable, errUnavailable, errNotLeader, errUnavailable},
+			wantAttempts: 3,
+			wantErrIs:    errNotLeader,
+		},
+		{
+			name:         "fail with non-transient error",
+			policy:       policy,
+			ctx:          context.Background(),
+			errs:         []error{errUnavailable, ErrTokenNotFound},
+			wantAttempts: 2,
+			wantErrIs:    ErrTokenNotFound,
+		},
+		{
+			name: "retry using custom classifier",
+			policy: &RetryPolicy{
+				MaxAttempts: 2,
+				Retryable: func(err error) bool {
+					return errors.Is(err, ErrTokenNotFound)
+				},
+			},
+			ctx:          context.Background(),
+			errs:         []error{ErrTokenNotFound},
+			wantAttempts: 2,
+		},
+		{
+			name:         "no retry without policy",
+			ctx:          context.Background(),
+			errs:         []error{errUnavailable},
+			wantAttempts: 1,
+			wantErrIs:    errUnavailable,
+		},
+		{
+			name:         "no retry in transaction",
+			policy:       policy,
+			ctx:          context.WithValue(context.Background(), transactionKey{}, driver.TransactionID("tx")),
+			errs:         []error{errUnavailable},
+			wantAttempts: 1,
+			wantErrIs:    errUnavailable,
+		},
+		{
+			name:         "no retry with canceled context",
+			policy:       &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+			ctx:          canceled,
+			errs:         []error{errUnavailable},
+			wantAttempts: 1,
+			wantErrIs:    errUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var attempts int
+			err := tt.policy.do(tt.ctx, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+
+				return nil
+			})
+
+			if attempts != tt.wantAttempts {
+				t.Errorf("do() attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+
+			if (err != nil) != (tt.wantErrIs != nil) || !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("do() error = %v, wantErrIs %v", err, tt.wantErrIs)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		retry   int
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{retry: 0, wantMin: 50 * time.Millisecond, wantMax: 100 * time.Millisecond},
+		{retry: 1, wantMin: 100 * time.Millisecond, wantMax: 200 * time.Millisecond},
+		{retry: 2, wantMin: 200 * time.Millisecond, wantMax: 400 * time.Millisecond},
+		{retry: 4, wantMin: 500 * time.Millisecond, wantMax: time.Second},
+		{retry: 100, wantMin: 500 * time.Millisecond, wantMax: time.Second},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("retry %d", tt.retry), func(t *testing.T) {
+			t.Parallel()
+			for i := 0; i < 100; i++ {
+				if got := policy.backoff(tt.retry); got < tt.wantMin || got > tt.wantMax {
+					t.Fatalf("backoff() got = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenStore_Retry(t *testing.T) {
+	ctx := context.Background()
+	query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
+	bindVars := map[string]any{
+		"@collection":  DefaultTokenStoreCollection,
+		"access_token": "test-access-token",
+	}
+
+	cursor := new(MockArangoCursor)
+	cursor.On("Close").Return(nil)
+	cursor.On("HasMore").Return(false, nil)
+
+	db := new(MockArangoDB)
+	db.On("Query", ctx, query, bindVars).Return(nil, errNotLeader).Once()
+	db.On("Query", ctx, query, bindVars).Return(cursor, nil).Once()
+
+	s := &TokenStore{
+		db:         db,
+		collection: DefaultTokenStoreCollection,
+		retry:      &RetryPolicy{MaxAttempts: 2},
+	}
+
+	_, err := s.GetByAccess(ctx, "test-access-token")
+	if !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, wantErrIs %v", err, ErrTokenNotFound)
+	}
+
+	db.AssertNumberOfCalls(t, "Query", 2)
+}
+
+func TestClientStore_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		rev          string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "retry update",
+			wantAttempts: 2,
+		},
+		{
+			name:         "no retry of update by revision",
+			rev:          "rev-1",
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			coll := new(MockArangoCollection)
+			coll.On("ReplaceDocument", mock.Anything, "client-id", mock.Anything).Return(driver.DocumentMeta{}, errUnavailable).Once()
+			coll.On("ReplaceDocument", mock.Anything, "client-id", mock.Anything).Return(driver.DocumentMeta{Rev: "rev-2"}, nil).Once()
+
+			db := new(MockArangoDB)
+			db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil)
+
+			s := &ClientStore{
+				db:         db,
+				collection: DefaultClientStoreCollection,
+				retry:      &RetryPolicy{MaxAttempts: 3},
+			}
+
+			rev, err := s.Update(context.Background(), &models.Client{ID: "client-id"}, tt.rev)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && rev != "rev-2" {
+				t.Errorf("Update() got = %v, want %v", rev, "rev-2")
+			}
+
+			coll.AssertNumberOfCalls(t, "ReplaceDocument", tt.wantAttempts)
+		})
+	}
+}
+
+func TestClientStore_Retry_Delete(t *testing.T) {
+	errNotFound := driver.ArangoError{HasError: true, Code: http.StatusNotFound, ErrorNum: driver.ErrArangoDocumentNotFound}
+
+	tests := []struct {
+		name      string
+		db        func() driver.Database
+		wantErrIs error
+	}{
+		{
+			name: "delete client removed by failed attempt",
+			db: func() driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("RemoveDocument", mock.Anything, "client-id").Return(driver.DocumentMeta{}, errUnavailable).Once()
+				coll.On("RemoveDocument", mock.Anything, "client-id").Return(driver.DocumentMeta{}, errNotFound).Once()
+
+				db := new(MockArangoDB)
+				db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+		},
+		{
+			name: "delete unknown client",
+			db: func() driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("RemoveDocument", mock.Anything, "client-id").Return(driver.DocumentMeta{}, errNotFound).Once()
+
+				db := new(MockArangoDB)
+				db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil)
+
+				return db
+			},
+			wantErrIs: ErrClientNotFound,
+		},
+		{
+			name: "delete unknown client after failed collection lookup",
+			db: func() driver.Database {
+				coll := new(MockArangoCollection)
+				coll.On("RemoveDocument", mock.Anything, "client-id").Return(driver.DocumentMeta{}, errNotFound).Once()
+
+				db := new(MockArangoDB)
+				db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(nil, errUnavailable).Once()
+				db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil).Once()
+
+				return db
+			},
+			wantErrIs: ErrClientNotFound,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &ClientStore{
+				db:         tt.db(),
+				collection: DefaultClientStoreCollection,
+				retry:      &RetryPolicy{MaxAttempts: 3},
+			}
+
+			err := s.Delete(context.Background(), "client-id")
+			if tt.wantErrIs == nil && err != nil {
+				t.Errorf("Delete() error = %v", err)
+			}
+
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("Delete() error = %v, wantErrIs %v", err, tt.wantErrIs)
+			}
+		})
+	}
+}
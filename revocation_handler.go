@@ -0,0 +1,96 @@
+package arangostore
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+// RevocationHandler is an http.Handler implementing the token revocation
+// endpoint described in RFC 7009.
+//
+// The client is authenticated using the client store, then the access or
+// refresh token presented in the token parameter is removed from the token
+// store. The token_type_hint parameter is used to look up the token, though
+// tokens of every type are found regardless of the hint. As an access token
+// and the refresh token issued along with it are stored together, revoking
+// either of them revokes both.
+type RevocationHandler struct {
+	clients oauth2.ClientStore
+	tokens  oauth2.TokenStore
+}
+
+// NewRevocationHandler creates a new RevocationHandler using the given stores.
+func NewRevocationHandler(clients oauth2.ClientStore, tokens oauth2.TokenStore) (*RevocationHandler, error) {
+	if clients == nil {
+		return nil, ErrNoClientStore
+	}
+
+	if tokens == nil {
+		return nil, ErrNoTokenStore
+	}
+
+	return &RevocationHandler{
+		clients: clients,
+		tokens:  tokens,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *RevocationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !parseTokenRequest(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+
+	client, err := authenticateClient(ctx, h.clients, r)
+	if err != nil {
+		if errors.Is(err, errInvalidClient) {
+			writeInvalidClient(w, r)
+			return
+		}
+
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "missing token")
+		return
+	}
+
+	info, tokenType, err := lookupToken(ctx, h.tokens, token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	// Invalid tokens do not cause an error, as the purpose of the revocation
+	// is already achieved.
+	if info == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if info.GetClientID() != client.GetID() {
+		writeError(w, http.StatusBadRequest, "unauthorized_client", "the token was not issued to the client")
+		return
+	}
+
+	switch tokenType {
+	case tokenTypeHintAccessToken:
+		err = h.tokens.RemoveByAccess(ctx, token)
+	case tokenTypeHintRefreshToken:
+		err = h.tokens.RemoveByRefresh(ctx, token)
+	}
+
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,240 @@
+package arangostore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+func TestRevocationHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    func() *http.Request
+		tokens     []oauth2.TokenInfo
+		wantStatus int
+		wantError  string
+		wantTokens int
+	}{
+		{
+			name: "revoke access token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"access-token"}, "token_type_hint": {"access_token"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token", Refresh: "refresh-token"},
+				&models.Token{ClientID: "client-id", Access: "other-access-token"},
+			},
+			wantStatus: http.StatusOK,
+			wantTokens: 1,
+		},
+		{
+			name: "revoke refresh token with paired access tokens",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"refresh-token"}, "token_type_hint": {"refresh_token"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token", Refresh: "refresh-token"},
+				&models.Token{ClientID: "client-id", Access: "new-access-token", Refresh: "refresh-token"},
+				&models.Token{ClientID: "client-id", Access: "other-access-token"},
+			},
+			wantStatus: http.StatusOK,
+			wantTokens: 1,
+		},
+		{
+			name: "revoke refresh token with wrong hint",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"refresh-token"}, "token_type_hint": {"access_token"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token", Refresh: "refresh-token"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "revoke token without hint",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"refresh-token"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token", Refresh: "refresh-token"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "revoke unknown token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"unknown-token"}})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token"},
+			},
+			wantStatus: http.StatusOK,
+			wantTokens: 1,
+		},
+		{
+			name: "revoke token of another client",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"access-token"}})
+				r.SetBasicAuth("other-id", "other-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token"},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unauthorized_client",
+			wantTokens: 1,
+		},
+		{
+			name: "revoke token with invalid client",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{"token": {"access-token"}})
+				r.SetBasicAuth("client-id", "invalid")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token"},
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+			wantTokens: 1,
+		},
+		{
+			name: "revoke token with client store error",
+			request: func() *http.Request {
+				return newFormRequest(url.Values{"token": {"access-token"}, "client_id": {"broken"}})
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "temporarily_unavailable",
+		},
+		{
+			name: "revoke without token",
+			request: func() *http.Request {
+				r := newFormRequest(url.Values{})
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_request",
+		},
+		{
+			name: "revoke using get request",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/?token=access-token", nil)
+				r.SetBasicAuth("client-id", "client-secret")
+
+				return r
+			},
+			tokens: []oauth2.TokenInfo{
+				&models.Token{ClientID: "client-id", Access: "access-token"},
+			},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "invalid_request",
+			wantTokens: 1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tokens := &fakeTokenStore{tokens: tt.tokens}
+
+			h, err := NewRevocationHandler(testClients, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.request())
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if got := decodeErrorResponse(t, w); got != tt.wantError {
+				t.Errorf("ServeHTTP() error = %v, want %v", got, tt.wantError)
+			}
+			if len(tokens.tokens) != tt.wantTokens {
+				t.Errorf("ServeHTTP() tokens = %v, want %v", len(tokens.tokens), tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestNewRevocationHandler(t *testing.T) {
+	if _, err := NewRevocationHandler(nil, new(fakeTokenStore)); err != ErrNoClientStore {
+		t.Errorf("NewRevocationHandler() error = %v, want %v", err, ErrNoClientStore)
+	}
+
+	if _, err := NewRevocationHandler(testClients, nil); err != ErrNoTokenStore {
+		t.Errorf("NewRevocationHandler() error = %v, want %v", err, ErrNoTokenStore)
+	}
+}
+
+func TestRevocationHandler_ServeHTTP_RotatedRefreshToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+	}{
+		{
+			name:     "revoke rotated refresh token of the client",
+			clientID: "client-id",
+			secret:   "client-secret",
+		},
+		{
+			name:     "revoke rotated refresh token of another client",
+			clientID: "other-id",
+			secret:   "other-secret",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tokens, refresh := newRotatedTokenStore(t)
+
+			h, err := NewRevocationHandler(testClients, tokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := newFormRequest(url.Values{"token": {refresh}, "token_type_hint": {"refresh_token"}})
+			r.SetBasicAuth(tt.clientID, tt.secret)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+			}
+
+			// Revoking a rotated refresh token must not revoke its family.
+			if _, err := tokens.GetByAccess(context.Background(), "new-access-token"); err != nil {
+				t.Errorf("GetByAccess() error = %v", err)
+			}
+		})
+	}
+}
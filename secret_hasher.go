@@ -0,0 +1,187 @@
+package arangostore
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SecretHasher hashes client secrets before they are stored and verifies the
+// secrets presented by the clients against the stored hashes.
+type SecretHasher interface {
+	// Hash returns the hash of the secret.
+	Hash(secret string) (string, error)
+	// Verify reports whether the secret matches the hash.
+	Verify(hash string, secret string) (bool, error)
+	// IsHash reports whether the value is a hash produced by the hasher.
+	IsHash(value string) bool
+}
+
+// BcryptSecretHasher is a SecretHasher using bcrypt.
+type BcryptSecretHasher struct {
+	cost int
+}
+
+// NewBcryptSecretHasher creates a new BcryptSecretHasher using the given cost.
+// If the cost is out of the range bcrypt supports, bcrypt.DefaultCost is used.
+func NewBcryptSecretHasher(cost int) *BcryptSecretHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	return &BcryptSecretHasher{
+		cost: cost,
+	}
+}
+
+// Hash returns the bcrypt hash of the secret.
+func (h *BcryptSecretHasher) Hash(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Verify reports whether the secret matches the bcrypt hash.
+func (h *BcryptSecretHasher) Verify(hash string, secret string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// IsHash reports whether the value is a bcrypt hash.
+func (h *BcryptSecretHasher) IsHash(value string) bool {
+	_, err := bcrypt.Cost([]byte(value))
+	return err == nil
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Limits of the argon2id parameters. Hashes exceeding them are rejected, so a
+// malformed hash cannot exhaust the memory or the CPU of the server.
+const (
+	argon2idMaxTime      = 64
+	argon2idMaxMemory    = 1 << 20 // 1 GiB
+	argon2idMinKeyLength = 16
+	argon2idMinSalt      = 8
+)
+
+// Default argon2id parameters, as recommended by RFC 9106, section 4.
+const (
+	argon2idDefaultTime    = 3
+	argon2idDefaultMemory  = 64 * 1024
+	argon2idDefaultThreads = 4
+)
+
+// validArgon2idParams reports whether argon2id can be used with the given
+// parameters within the limits.
+func validArgon2idParams(time uint32, memory uint32, threads uint8) bool {
+	return time >= 1 && time <= argon2idMaxTime &&
+		threads >= 1 &&
+		memory >= 8*uint32(threads) && memory <= argon2idMaxMemory
+}
+
+// Argon2idSecretHasher is a SecretHasher using argon2id. The hashes are
+// encoded in the PHC string format, so the parameters used to create a hash
+// are stored along with it.
+type Argon2idSecretHasher struct {
+	time       uint32
+	memory     uint32
+	threads    uint8
+	keyLength  uint32
+	saltLength uint32
+}
+
+// NewArgon2idSecretHasher creates a new Argon2idSecretHasher using the given
+// number of iterations, memory in KiB and degree of parallelism. If the
+// parameters are out of the range argon2id supports, at most 64 iterations
+// and 1 GiB of memory, the parameters recommended by RFC 9106 are used.
+func NewArgon2idSecretHasher(time uint32, memory uint32, threads uint8) *Argon2idSecretHasher {
+	if !validArgon2idParams(time, memory, threads) {
+		time, memory, threads = argon2idDefaultTime, argon2idDefaultMemory, argon2idDefaultThreads
+	}
+
+	return &Argon2idSecretHasher{
+		time:       time,
+		memory:     memory,
+		threads:    threads,
+		keyLength:  32,
+		saltLength: 16,
+	}
+}
+
+// Hash returns the argon2id hash of the secret.
+func (h *Argon2idSecretHasher) Hash(secret string) (string, error) {
+	if !validArgon2idParams(h.time, h.memory, h.threads) || h.keyLength < argon2idMinKeyLength || h.saltLength < argon2idMinSalt {
+		return "", ErrInvalidHashParams
+	}
+
+	salt := make([]byte, h.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(secret), salt, h.time, h.memory, h.threads, h.keyLength)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.memory,
+		h.time,
+		h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether the secret matches the argon2id hash.
+func (h *Argon2idSecretHasher) Verify(hash string, secret string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrInvalidHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || !validArgon2idParams(time, memory, threads) {
+		return false, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2idMinSalt {
+		return false, ErrInvalidHash
+	}
+
+	// An empty key would match every secret.
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2idMinKeyLength {
+		return false, ErrInvalidHash
+	}
+
+	// nolint: gosec
+	other := argon2.IDKey([]byte(secret), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// IsHash reports whether the value is an argon2id hash.
+func (h *Argon2idSecretHasher) IsHash(value string) bool {
+	return strings.HasPrefix(value, argon2idPrefix)
+}
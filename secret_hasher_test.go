@@ -0,0 +1,177 @@
+package arangostore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSecretHasher(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher SecretHasher
+	}{
+		{
+			name:   "bcrypt",
+			hasher: NewBcryptSecretHasher(4),
+		},
+		{
+			name:   "argon2id",
+			hasher: NewArgon2idSecretHasher(1, 64, 1),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			hash, err := tt.hasher.Hash("client-secret")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+
+			if !tt.hasher.IsHash(hash) {
+				t.Errorf("IsHash() got = false, want true")
+			}
+
+			if tt.hasher.IsHash("client-secret") {
+				t.Errorf("IsHash() got = true for plaintext, want false")
+			}
+
+			ok, err := tt.hasher.Verify(hash, "client-secret")
+			if err != nil || !ok {
+				t.Errorf("Verify() got = %v, %v, want true, nil", ok, err)
+			}
+
+			ok, err = tt.hasher.Verify(hash, "other-secret")
+			if err != nil || ok {
+				t.Errorf("Verify() got = %v, %v, want false, nil", ok, err)
+			}
+		})
+	}
+}
+
+func TestArgon2idSecretHasher_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		hash    string
+		wantErr bool
+	}{
+		{
+			name:    "verify with invalid prefix",
+			hash:    "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5",
+			wantErr: true,
+		},
+		{
+			name:    "verify with invalid version",
+			hash:    "$argon2id$v=1$m=64,t=1,p=1$c2FsdA$a2V5",
+			wantErr: true,
+		},
+		{
+			name:    "verify with invalid parameters",
+			hash:    "$argon2id$v=19$m=64$c2FsdA$a2V5",
+			wantErr: true,
+		},
+		{
+			name:    "verify with invalid salt",
+			hash:    "$argon2id$v=19$m=64,t=1,p=1$!$a2V5",
+			wantErr: true,
+		},
+		{
+			name:    "verify with zero iterations",
+			hash:    "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+			wantErr: true,
+		},
+		{
+			name:    "verify with zero threads",
+			hash:    "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHRzYWx0c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+			wantErr: true,
+		},
+		{
+			name:    "verify with too much memory",
+			hash:    "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+			wantErr: true,
+		},
+		{
+			name:    "verify with too many iterations",
+			hash:    "$argon2id$v=19$m=64,t=100000,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+			wantErr: true,
+		},
+		{
+			name:    "verify with short salt",
+			hash:    "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+			wantErr: true,
+		},
+		{
+			name:    "verify with empty key",
+			hash:    "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$",
+			wantErr: true,
+		},
+		{
+			name: "verify with valid parameters",
+			hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2tra2tra2tra2tra2tra2tra2tra2tra2tra2tra2s",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h := NewArgon2idSecretHasher(1, 64, 1)
+			if _, err := h.Verify(tt.hash, "client-secret"); (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewArgon2idSecretHasher(t *testing.T) {
+	tests := []struct {
+		name        string
+		time        uint32
+		memory      uint32
+		threads     uint8
+		wantTime    uint32
+		wantMemory  uint32
+		wantThreads uint8
+	}{
+		{
+			name:        "new hasher",
+			time:        1,
+			memory:      64,
+			threads:     1,
+			wantTime:    1,
+			wantMemory:  64,
+			wantThreads: 1,
+		},
+		{
+			name:        "new hasher with zero parameters",
+			wantTime:    argon2idDefaultTime,
+			wantMemory:  argon2idDefaultMemory,
+			wantThreads: argon2idDefaultThreads,
+		},
+		{
+			name:        "new hasher with too much memory",
+			time:        1,
+			memory:      argon2idMaxMemory + 1,
+			threads:     1,
+			wantTime:    argon2idDefaultTime,
+			wantMemory:  argon2idDefaultMemory,
+			wantThreads: argon2idDefaultThreads,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			h := NewArgon2idSecretHasher(tt.time, tt.memory, tt.threads)
+			if h.time != tt.wantTime || h.memory != tt.wantMemory || h.threads != tt.wantThreads {
+				t.Errorf("NewArgon2idSecretHasher() got = %v, %v, %v, want %v, %v, %v", h.time, h.memory, h.threads, tt.wantTime, tt.wantMemory, tt.wantThreads)
+			}
+		})
+	}
+}
+
+func TestArgon2idSecretHasher_Hash(t *testing.T) {
+	h := new(Argon2idSecretHasher)
+	if _, err := h.Hash("client-secret"); !errors.Is(err, ErrInvalidHashParams) {
+		t.Errorf("Hash() error = %v, wantErrIs %v", err, ErrInvalidHashParams)
+	}
+}
@@ -0,0 +1,487 @@
+package arangostore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	arangostore "github.com/gabor-boros/go-oauth2-arangodb"
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+	"github.com/gabor-boros/go-oauth2-arangodb/storetest"
+)
+
+// newTokenStore returns a token store using an in-memory database.
+func newTokenStore(t *testing.T, opts ...arangostore.TokenStoreOption) *arangostore.TokenStore {
+	t.Helper()
+
+	opts = append([]arangostore.TokenStoreOption{
+		arangostore.WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+		arangostore.WithTokenStoreEnsureSchema(nil),
+	}, opts...)
+
+	s, err := arangostore.NewTokenStore(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestTokenStore(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreRejectExpired())
+
+	for _, token := range []*models.Token{
+		storetest.NewToken("", "access", "refresh"),
+		storetest.NewToken("", "other-access", ""),
+	} {
+		if err := s.Create(ctx, token); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	if info, err := s.GetByAccess(ctx, "access"); err != nil || info.GetRefresh() != "refresh" {
+		t.Errorf("GetByAccess() = %v, %v", info, err)
+	}
+
+	if info, err := s.GetByRefresh(ctx, "refresh"); err != nil || info.GetAccess() != "access" {
+		t.Errorf("GetByRefresh() = %v, %v", info, err)
+	}
+
+	tokens, next, err := s.ListByUser(ctx, "user-id", "", 1)
+	if err != nil || len(tokens) != 1 || next == "" {
+		t.Fatalf("ListByUser() = %v, %v, %v", tokens, next, err)
+	}
+
+	if tokens, next, err = s.ListByUser(ctx, "user-id", next, 1); err != nil || len(tokens) != 1 {
+		t.Errorf("ListByUser() = %v, %v, %v", tokens, next, err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatalf("RemoveByRefresh() error = %v", err)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+
+	if count, err := s.CountByUser(ctx, "user-id"); err != nil || count != 1 {
+		t.Errorf("CountByUser() = %v, %v, want 1", count, err)
+	}
+
+	if err := s.RemoveByClient(ctx, "client-id"); err != nil {
+		t.Fatalf("RemoveByClient() error = %v", err)
+	}
+
+	if count, err := s.CountByUser(ctx, "user-id"); err != nil || count != 0 {
+		t.Errorf("CountByUser() = %v, %v, want 0", count, err)
+	}
+}
+
+func TestTokenStore_WithTransaction_Rollback(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t)
+
+	errAbort := fmt.Errorf("abort")
+	err := s.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.Create(ctx, storetest.NewToken("", "access", "")); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTransaction() error = %v, want %v", err, errAbort)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+}
+
+func TestTokenStore_Migrate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+	db := arangotest.NewDatabase("test")
+	s := newTokenStore(t, arangostore.WithTokenStoreDatabase(db))
+
+	coll, err := db.Collection(ctx, arangostore.DefaultTokenStoreCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A document stored before the expiries and owners were stored.
+	_, err = coll.CreateDocument(ctx, map[string]any{
+		"code":          "",
+		"access_token":  "access",
+		"refresh_token": "",
+		"data":          storetest.NewToken("", "access", ""),
+		"created_at":    now,
+		"expires_at":    now.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated, err := s.MigrateExpiries(ctx); err != nil || migrated != 1 {
+		t.Errorf("MigrateExpiries() = %v, %v, want 1", migrated, err)
+	}
+
+	if migrated, err := s.MigrateOwners(ctx); err != nil || migrated != 1 {
+		t.Errorf("MigrateOwners() = %v, %v, want 1", migrated, err)
+	}
+
+	enc, err := arangostore.NewAESGCMEncryptor("key", map[string][]byte{"key": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTokenStore(t, arangostore.WithTokenStoreDatabase(db), arangostore.WithTokenStoreEncryptor(enc))
+
+	if reencrypted, err := s.Reencrypt(ctx); err != nil || reencrypted != 1 {
+		t.Errorf("Reencrypt() = %v, %v, want 1", reencrypted, err)
+	}
+
+	if tokens, _, err := s.ListByUser(ctx, "user-id", "", 10); err != nil || len(tokens) != 1 || tokens[0].GetAccess() != "access" {
+		t.Errorf("ListByUser() = %v, %v", tokens, err)
+	}
+}
+
+func TestClientStore(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := arangostore.NewClientStore(
+		arangostore.WithClientStoreDatabase(arangotest.NewDatabase("test")),
+		arangostore.WithClientStoreEnsureSchema(nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"client-1", "client-2", "client-3"} {
+		if err := s.Create(&models.Client{ID: id, Secret: "secret", Domain: "example.com"}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	_, rev, err := s.GetWithRevision(ctx, "client-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Update(ctx, &models.Client{ID: "client-1", Domain: "example.org"}, rev); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	if _, err := s.Update(ctx, &models.Client{ID: "client-1"}, rev); !errors.Is(err, arangostore.ErrRevisionMismatch) {
+		t.Errorf("Update() error = %v, want %v", err, arangostore.ErrRevisionMismatch)
+	}
+
+	if info, err := s.GetByID(ctx, "client-1"); err != nil || info.GetDomain() != "example.org" {
+		t.Errorf("GetByID() = %v, %v", info, err)
+	}
+
+	clients, next, err := s.List(ctx, "client-1", 10)
+	if err != nil || len(clients) != 2 || next != "" {
+		t.Errorf("List() = %v, %v, %v", clients, next, err)
+	}
+
+	if err := s.Delete(ctx, "client-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := s.GetByID(ctx, "client-1"); !errors.Is(err, arangostore.ErrClientNotFound) {
+		t.Errorf("GetByID() error = %v, want %v", err, arangostore.ErrClientNotFound)
+	}
+}
+
+func TestTokenStore_ConsumeByCode_Reused(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreCodeReuseRevocation())
+
+	for _, code := range []string{"code", "other-code"} {
+		if err := s.Create(ctx, storetest.NewToken(code, "", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if info, err := s.ConsumeByCode(ctx, "code"); err != nil || info.GetCode() != "code" {
+		t.Fatalf("ConsumeByCode() = %v, %v", info, err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByCode(ctx, "code"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByCode() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "other-code"); err != nil {
+		t.Fatalf("ConsumeByCode() error = %v", err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "other-access", "other-refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "code"); !errors.Is(err, arangostore.ErrCodeReuse) {
+		t.Errorf("ConsumeByCode() error = %v, want %v", err, arangostore.ErrCodeReuse)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+
+	// The token issued from the other code is kept.
+	if _, err := s.GetByAccess(ctx, "other-access"); err != nil {
+		t.Errorf("GetByAccess() error = %v", err)
+	}
+}
+
+func TestTokenStore_ConsumeByCode_Concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	// Every reading of the clock is a millisecond later, so the codes are
+	// consumed in order.
+	now := time.Now()
+	clock := func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+
+	s := newTokenStore(t,
+		arangostore.WithTokenStoreCodeReuseRevocation(),
+		arangostore.WithTokenStoreClock(clock),
+	)
+
+	for _, code := range []string{"code", "other-code"} {
+		if err := s.Create(ctx, storetest.NewToken(code, "", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both codes are redeemed for the same user and client before any token
+	// is issued from them.
+	for _, code := range []string{"code", "other-code"} {
+		if _, err := s.ConsumeByCode(ctx, code); err != nil {
+			t.Fatalf("ConsumeByCode() error = %v", err)
+		}
+	}
+
+	// The token issued from the code is created first, so it claims the code
+	// consumed last, and the codes are linked to each other's token.
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "other-access", "other-refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "code"); !errors.Is(err, arangostore.ErrCodeReuse) {
+		t.Errorf("ConsumeByCode() error = %v, want %v", err, arangostore.ErrCodeReuse)
+	}
+
+	if _, err := s.GetByAccess(ctx, "other-access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); err != nil {
+		t.Errorf("GetByAccess() error = %v", err)
+	}
+}
+
+func TestTokenStore_ConsumeByCode_Refreshed(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t,
+		arangostore.WithTokenStoreCodeReuseRevocation(),
+		arangostore.WithTokenStoreRefreshRotation(time.Hour),
+	)
+
+	if err := s.Create(ctx, storetest.NewToken("code", "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "code"); err != nil {
+		t.Fatalf("ConsumeByCode() error = %v", err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.GetByRefresh(ctx, "refresh")
+	if err != nil {
+		t.Fatalf("GetByRefresh() error = %v", err)
+	}
+
+	info.SetAccess("new-access")
+	info.SetRefresh("new-refresh")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "code"); !errors.Is(err, arangostore.ErrCodeReuse) {
+		t.Errorf("ConsumeByCode() error = %v, want %v", err, arangostore.ErrCodeReuse)
+	}
+
+	// The token refreshed from the token issued from the code is revoked too.
+	if _, err := s.GetByAccess(ctx, "new-access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+}
+
+func TestTokenStore_RefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreRefreshRotation(time.Hour))
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refresh the token the way the manager of go-oauth2 does.
+	info, err := s.GetByRefresh(ctx, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.SetAccess("rotated-access")
+	info.SetRefresh("rotated-refresh")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveByAccess(ctx, "access"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByAccess(ctx, "rotated-access"); err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	if _, err := s.GetByRefresh(ctx, "refresh"); !errors.Is(err, arangostore.ErrRefreshTokenReuse) {
+		t.Errorf("GetByRefresh() error = %v, want %v", err, arangostore.ErrRefreshTokenReuse)
+	}
+
+	if _, err := s.GetByAccess(ctx, "rotated-access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+}
+
+func TestTokenStore_WithTransaction_RefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreRefreshRotation(time.Hour))
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.GetByRefresh(ctx, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.SetAccess("rotated-access")
+	info.SetRefresh("rotated-refresh")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.GetByRefresh(ctx, "refresh")
+		return err
+	})
+	if !errors.Is(err, arangostore.ErrRefreshTokenReuse) {
+		t.Fatalf("WithTransaction() error = %v, want %v", err, arangostore.ErrRefreshTokenReuse)
+	}
+
+	// The family revoked in the transaction stays revoked.
+	if _, err := s.GetByAccess(ctx, "rotated-access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+}
+
+func TestTokenStore_WithTransaction_CodeReuse(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreCodeReuseRevocation())
+
+	if err := s.Create(ctx, storetest.NewToken("code", "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ConsumeByCode(ctx, "code"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.ConsumeByCode(ctx, "code")
+		return err
+	})
+	if !errors.Is(err, arangostore.ErrCodeReuse) {
+		t.Fatalf("WithTransaction() error = %v, want %v", err, arangostore.ErrCodeReuse)
+	}
+
+	// The tokens revoked in the transaction stay revoked.
+	if _, err := s.GetByAccess(ctx, "access"); !errors.Is(err, arangostore.ErrTokenNotFound) {
+		t.Errorf("GetByAccess() error = %v, want %v", err, arangostore.ErrTokenNotFound)
+	}
+}
+
+func TestTokenStore_GetByEmptyValue(t *testing.T) {
+	ctx := context.Background()
+	s := newTokenStore(t, arangostore.WithTokenStoreRefreshRotation(time.Hour))
+
+	if err := s.Create(ctx, storetest.NewToken("code", "", "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, storetest.NewToken("", "access", "refresh")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating the refresh token leaves a tombstone holding no token.
+	info, err := s.GetByRefresh(ctx, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.SetAccess("rotated-access")
+	info.SetRefresh("rotated-refresh")
+
+	if err := s.Create(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := map[string]func(ctx context.Context, value string) (oauth2.TokenInfo, error){
+		"GetByCode":     s.GetByCode,
+		"GetByAccess":   s.GetByAccess,
+		"GetByRefresh":  s.GetByRefresh,
+		"FindByRefresh": s.FindByRefresh,
+	}
+	for name, lookup := range lookups {
+		if info, err := lookup(ctx, ""); !errors.Is(err, arangostore.ErrTokenNotFound) {
+			t.Errorf("%s() = %v, %v, want %v", name, info, err, arangostore.ErrTokenNotFound)
+		}
+	}
+}
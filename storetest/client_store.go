@@ -0,0 +1,161 @@
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	arangostore "github.com/gabor-boros/go-oauth2-arangodb"
+)
+
+// ClientStoreFactory returns a new client store holding the given clients for
+// every test.
+type ClientStoreFactory func(t *testing.T, clients ...oauth2.ClientInfo) oauth2.ClientStore
+
+// RunClientStoreSuite runs the conformance test suite of client stores
+// against the stores returned by newStore.
+//
+// Clients returned by the stores must hold the ID, domain, user ID and
+// whether the client is public. Their secret must either be returned as is,
+// or verified by implementing oauth2.ClientPasswordVerifier, as it is when
+// the secrets are hashed.
+func RunClientStoreSuite(t *testing.T, newStore ClientStoreFactory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, newStore ClientStoreFactory)
+	}{
+		{name: "get by id", run: testGetByID},
+		{name: "get unknown client", run: testGetUnknownClient},
+		{name: "concurrent access", run: testConcurrentClientAccess},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore)
+		})
+	}
+}
+
+var testClients = []*models.Client{
+	{ID: "confidential", Secret: "secret", Domain: "https://example.com", UserID: "user-id"},
+	{ID: "public", Domain: "https://example.org", Public: true},
+}
+
+func clientInfos(clients []*models.Client) []oauth2.ClientInfo {
+	infos := make([]oauth2.ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		client := *c
+		infos = append(infos, &client)
+	}
+
+	return infos
+}
+
+// checkClient reports an error if the client does not match the wanted one.
+func checkClient(t *testing.T, got oauth2.ClientInfo, want *models.Client) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("client = nil, want %v", want)
+	}
+
+	if got.GetID() != want.ID {
+		t.Errorf("GetID() = %v, want %v", got.GetID(), want.ID)
+	}
+
+	if got.GetDomain() != want.Domain {
+		t.Errorf("GetDomain() = %v, want %v", got.GetDomain(), want.Domain)
+	}
+
+	if got.GetUserID() != want.UserID {
+		t.Errorf("GetUserID() = %v, want %v", got.GetUserID(), want.UserID)
+	}
+
+	if got.IsPublic() != want.Public {
+		t.Errorf("IsPublic() = %v, want %v", got.IsPublic(), want.Public)
+	}
+
+	if want.Secret == "" {
+		return
+	}
+
+	verifier, ok := got.(oauth2.ClientPasswordVerifier)
+	if !ok {
+		if got.GetSecret() != want.Secret {
+			t.Errorf("GetSecret() = %v, want %v", got.GetSecret(), want.Secret)
+		}
+
+		return
+	}
+
+	if !verifier.VerifyPassword(want.Secret) {
+		t.Errorf("VerifyPassword(%q) = false, want true", want.Secret)
+	}
+
+	if verifier.VerifyPassword(want.Secret + "-wrong") {
+		t.Errorf("VerifyPassword(%q) = true, want false", want.Secret+"-wrong")
+	}
+}
+
+func testGetByID(t *testing.T, newStore ClientStoreFactory) {
+	s := newStore(t, clientInfos(testClients)...)
+
+	for _, want := range testClients {
+		got, err := s.GetByID(context.Background(), want.ID)
+		if err != nil {
+			t.Fatalf("GetByID(%q) error = %v", want.ID, err)
+		}
+
+		checkClient(t, got, want)
+	}
+}
+
+func testGetUnknownClient(t *testing.T, newStore ClientStoreFactory) {
+	s := newStore(t, clientInfos(testClients)...)
+
+	info, err := s.GetByID(context.Background(), "unknown")
+	if !notFound(info == nil, err, arangostore.ErrClientNotFound) {
+		t.Errorf("GetByID() = %v, %v, want not found", info, err)
+	}
+}
+
+func testConcurrentClientAccess(t *testing.T, newStore ClientStoreFactory) {
+	clients := make([]*models.Client, 0, 20)
+	for i := 0; i < cap(clients); i++ {
+		clients = append(clients, &models.Client{
+			ID:     fmt.Sprintf("client-%d", i),
+			Secret: fmt.Sprintf("secret-%d", i),
+			Domain: "https://example.com",
+		})
+	}
+
+	s := newStore(t, clientInfos(clients)...)
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		client := client
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			got, err := s.GetByID(context.Background(), client.ID)
+			if err != nil {
+				t.Errorf("GetByID(%q) error = %v", client.ID, err)
+				return
+			}
+
+			if got == nil || got.GetID() != client.ID {
+				t.Errorf("GetByID(%q) = %v", client.ID, got)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,33 @@
+// Package storetest provides conformance test suites for implementations of
+// oauth2.TokenStore and oauth2.ClientStore, such as the stores of this module
+// or wrappers around them.
+//
+// The suites check the behavior the manager of go-oauth2 relies on. Lookups
+// of unknown values must either return nil without error, or an error
+// matching arangostore.ErrTokenNotFound or arangostore.ErrClientNotFound.
+package storetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// notFound reports whether the lookup result means the value was not found.
+func notFound(isNil bool, err error, sentinel error) bool {
+	if err == nil {
+		return isNil
+	}
+
+	return isNil && errors.Is(err, sentinel)
+}
+
+// checkTime reports an error if the times differ. The stores may not keep the
+// monotonic clock reading or the location, so the instants are compared.
+func checkTime(t *testing.T, field string, got time.Time, want time.Time) {
+	t.Helper()
+
+	if !got.Equal(want) {
+		t.Errorf("%s = %v, want %v", field, got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package storetest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+
+	arangostore "github.com/gabor-boros/go-oauth2-arangodb"
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
+	"github.com/gabor-boros/go-oauth2-arangodb/storetest"
+)
+
+func newEncryptor(t *testing.T) arangostore.Encryptor {
+	t.Helper()
+
+	enc, err := arangostore.NewAESGCMEncryptor("key", map[string][]byte{"key": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return enc
+}
+
+func TestTokenStore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []arangostore.TokenStoreOption
+	}{
+		{
+			name: "default",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption { return nil },
+		},
+		{
+			name: "not found as nil",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{arangostore.WithTokenStoreNotFoundAsNil()}
+			},
+		},
+		{
+			name: "reject expired",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{arangostore.WithTokenStoreRejectExpired()}
+			},
+		},
+		{
+			name: "hashed tokens",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{arangostore.WithTokenStoreHashKey([]byte("hash-key"))}
+			},
+		},
+		{
+			name: "encrypted data",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{arangostore.WithTokenStoreEncryptor(newEncryptor(t))}
+			},
+		},
+		{
+			name: "data as object",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{arangostore.WithTokenStoreDataAsObject()}
+			},
+		},
+		{
+			name: "refresh rotation and code reuse revocation",
+			opts: func(t *testing.T) []arangostore.TokenStoreOption {
+				return []arangostore.TokenStoreOption{
+					arangostore.WithTokenStoreRefreshRotation(time.Hour),
+					arangostore.WithTokenStoreCodeReuseRevocation(),
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunTokenStoreSuite(t, func(t *testing.T) oauth2.TokenStore {
+				opts := append([]arangostore.TokenStoreOption{
+					arangostore.WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+					arangostore.WithTokenStoreEnsureSchema(nil),
+				}, tt.opts(t)...)
+
+				s, err := arangostore.NewTokenStore(opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return s
+			})
+		})
+	}
+}
+
+func TestCachedTokenStore(t *testing.T) {
+	storetest.RunTokenStoreSuite(t, func(t *testing.T) oauth2.TokenStore {
+		s, err := arangostore.NewTokenStore(
+			arangostore.WithTokenStoreDatabase(arangotest.NewDatabase("test")),
+			arangostore.WithTokenStoreEnsureSchema(nil),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cached, err := arangostore.NewCachedTokenStore(s, 100, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cached
+	})
+}
+
+func TestClientStore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []arangostore.ClientStoreOption
+	}{
+		{
+			name: "default",
+			opts: func(t *testing.T) []arangostore.ClientStoreOption { return nil },
+		},
+		{
+			name: "not found as nil",
+			opts: func(t *testing.T) []arangostore.ClientStoreOption {
+				return []arangostore.ClientStoreOption{arangostore.WithClientStoreNotFoundAsNil()}
+			},
+		},
+		{
+			name: "hashed secrets",
+			opts: func(t *testing.T) []arangostore.ClientStoreOption {
+				return []arangostore.ClientStoreOption{arangostore.WithClientStoreSecretHasher(arangostore.NewBcryptSecretHasher(4))}
+			},
+		},
+		{
+			name: "encrypted data",
+			opts: func(t *testing.T) []arangostore.ClientStoreOption {
+				return []arangostore.ClientStoreOption{arangostore.WithClientStoreEncryptor(newEncryptor(t))}
+			},
+		},
+		{
+			name: "cached",
+			opts: func(t *testing.T) []arangostore.ClientStoreOption {
+				return []arangostore.ClientStoreOption{arangostore.WithClientStoreCache(100, time.Minute, time.Minute)}
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunClientStoreSuite(t, func(t *testing.T, clients ...oauth2.ClientInfo) oauth2.ClientStore {
+				opts := append([]arangostore.ClientStoreOption{
+					arangostore.WithClientStoreDatabase(arangotest.NewDatabase("test")),
+					arangostore.WithClientStoreEnsureSchema(nil),
+				}, tt.opts(t)...)
+
+				s, err := arangostore.NewClientStore(opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for _, client := range clients {
+					if err := s.Create(client); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				return s
+			})
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+
+	arangostore "github.com/gabor-boros/go-oauth2-arangodb"
+)
+
+// TokenStoreFactory returns a new, empty token store for every test.
+type TokenStoreFactory func(t *testing.T) oauth2.TokenStore
+
+// RunTokenStoreSuite runs the conformance test suite of token stores against
+// the stores returned by newStore.
+//
+// Tokens returned by a lookup must hold the value they were looked up by, the
+// IDs of their client and user, their scope, redirect URI and expiry. The
+// other token values of the same token may be missing, as they are when the
+// tokens are hashed.
+func RunTokenStoreSuite(t *testing.T, newStore TokenStoreFactory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s oauth2.TokenStore)
+	}{
+		{name: "get by code", run: testGetByCode},
+		{name: "get by access", run: testGetByAccess},
+		{name: "get by refresh", run: testGetByRefresh},
+		{name: "get unknown token", run: testGetUnknown},
+		{name: "remove by code", run: testRemoveByCode},
+		{name: "remove by access", run: testRemoveByAccess},
+		{name: "remove by refresh", run: testRemoveByRefresh},
+		{name: "remove unknown token", run: testRemoveUnknown},
+		{name: "get expired token", run: testGetExpired},
+		{name: "concurrent access", run: testConcurrentAccess},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+// NewToken returns a token of the client client-id and the user user-id
+// created now, holding the given values. Empty values are not set, along with
+// their creation time and expiry.
+func NewToken(code string, access string, refresh string) *models.Token {
+	// Stores may not keep sub-second precision.
+	now := time.Now().Truncate(time.Second)
+
+	token := &models.Token{
+		ClientID:    "client-id",
+		UserID:      "user-id",
+		RedirectURI: "https://example.com/callback",
+		Scope:       "read write",
+	}
+
+	if code != "" {
+		token.Code = code
+		token.CodeCreateAt = now
+		token.CodeExpiresIn = 10 * time.Minute
+	}
+
+	if access != "" {
+		token.Access = access
+		token.AccessCreateAt = now
+		token.AccessExpiresIn = time.Hour
+	}
+
+	if refresh != "" {
+		token.Refresh = refresh
+		token.RefreshCreateAt = now
+		token.RefreshExpiresIn = 24 * time.Hour
+	}
+
+	return token
+}
+
+func create(t *testing.T, s oauth2.TokenStore, token oauth2.TokenInfo) {
+	t.Helper()
+
+	if err := s.Create(context.Background(), token); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+}
+
+// checkToken reports an error if the token does not hold the metadata of the
+// wanted token.
+func checkToken(t *testing.T, got oauth2.TokenInfo, want oauth2.TokenInfo) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("token = nil, want %v", want)
+	}
+
+	if got.GetClientID() != want.GetClientID() {
+		t.Errorf("GetClientID() = %v, want %v", got.GetClientID(), want.GetClientID())
+	}
+
+	if got.GetUserID() != want.GetUserID() {
+		t.Errorf("GetUserID() = %v, want %v", got.GetUserID(), want.GetUserID())
+	}
+
+	if got.GetRedirectURI() != want.GetRedirectURI() {
+		t.Errorf("GetRedirectURI() = %v, want %v", got.GetRedirectURI(), want.GetRedirectURI())
+	}
+
+	if got.GetScope() != want.GetScope() {
+		t.Errorf("GetScope() = %v, want %v", got.GetScope(), want.GetScope())
+	}
+}
+
+func checkTokenNotFound(t *testing.T, lookup string, info oauth2.TokenInfo, err error) {
+	t.Helper()
+
+	if !notFound(info == nil, err, arangostore.ErrTokenNotFound) {
+		t.Errorf("%s() = %v, %v, want not found", lookup, info, err)
+	}
+}
+
+func testGetByCode(t *testing.T, s oauth2.TokenStore) {
+	want := NewToken("code", "", "")
+	create(t, s, want)
+
+	got, err := s.GetByCode(context.Background(), "code")
+	if err != nil {
+		t.Fatalf("GetByCode() error = %v", err)
+	}
+
+	checkToken(t, got, want)
+
+	if got.GetCode() != want.Code {
+		t.Errorf("GetCode() = %v, want %v", got.GetCode(), want.Code)
+	}
+
+	checkTime(t, "GetCodeCreateAt()", got.GetCodeCreateAt(), want.CodeCreateAt)
+
+	if got.GetCodeExpiresIn() != want.CodeExpiresIn {
+		t.Errorf("GetCodeExpiresIn() = %v, want %v", got.GetCodeExpiresIn(), want.CodeExpiresIn)
+	}
+}
+
+func testGetByAccess(t *testing.T, s oauth2.TokenStore) {
+	want := NewToken("", "access", "refresh")
+	create(t, s, want)
+
+	got, err := s.GetByAccess(context.Background(), "access")
+	if err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	checkToken(t, got, want)
+
+	if got.GetAccess() != want.Access {
+		t.Errorf("GetAccess() = %v, want %v", got.GetAccess(), want.Access)
+	}
+
+	checkTime(t, "GetAccessCreateAt()", got.GetAccessCreateAt(), want.AccessCreateAt)
+
+	if got.GetAccessExpiresIn() != want.AccessExpiresIn {
+		t.Errorf("GetAccessExpiresIn() = %v, want %v", got.GetAccessExpiresIn(), want.AccessExpiresIn)
+	}
+}
+
+func testGetByRefresh(t *testing.T, s oauth2.TokenStore) {
+	want := NewToken("", "access", "refresh")
+	create(t, s, want)
+
+	got, err := s.GetByRefresh(context.Background(), "refresh")
+	if err != nil {
+		t.Fatalf("GetByRefresh() error = %v", err)
+	}
+
+	checkToken(t, got, want)
+
+	if got.GetRefresh() != want.Refresh {
+		t.Errorf("GetRefresh() = %v, want %v", got.GetRefresh(), want.Refresh)
+	}
+
+	checkTime(t, "GetRefreshCreateAt()", got.GetRefreshCreateAt(), want.RefreshCreateAt)
+
+	if got.GetRefreshExpiresIn() != want.RefreshExpiresIn {
+		t.Errorf("GetRefreshExpiresIn() = %v, want %v", got.GetRefreshExpiresIn(), want.RefreshExpiresIn)
+	}
+}
+
+func testGetUnknown(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+	create(t, s, NewToken("code", "", ""))
+	create(t, s, NewToken("", "access", "refresh"))
+
+	info, err := s.GetByCode(ctx, "unknown")
+	checkTokenNotFound(t, "GetByCode", info, err)
+
+	info, err = s.GetByAccess(ctx, "unknown")
+	checkTokenNotFound(t, "GetByAccess", info, err)
+
+	info, err = s.GetByRefresh(ctx, "unknown")
+	checkTokenNotFound(t, "GetByRefresh", info, err)
+}
+
+func testRemoveByCode(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+	create(t, s, NewToken("code", "", ""))
+	create(t, s, NewToken("other-code", "", ""))
+
+	if err := s.RemoveByCode(ctx, "code"); err != nil {
+		t.Fatalf("RemoveByCode() error = %v", err)
+	}
+
+	info, err := s.GetByCode(ctx, "code")
+	checkTokenNotFound(t, "GetByCode", info, err)
+
+	if _, err := s.GetByCode(ctx, "other-code"); err != nil {
+		t.Errorf("GetByCode() error = %v", err)
+	}
+}
+
+func testRemoveByAccess(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+	create(t, s, NewToken("", "access", "refresh"))
+	create(t, s, NewToken("", "other-access", "other-refresh"))
+
+	if err := s.RemoveByAccess(ctx, "access"); err != nil {
+		t.Fatalf("RemoveByAccess() error = %v", err)
+	}
+
+	info, err := s.GetByAccess(ctx, "access")
+	checkTokenNotFound(t, "GetByAccess", info, err)
+
+	if _, err := s.GetByAccess(ctx, "other-access"); err != nil {
+		t.Errorf("GetByAccess() error = %v", err)
+	}
+}
+
+func testRemoveByRefresh(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+	create(t, s, NewToken("", "access", "refresh"))
+	create(t, s, NewToken("", "other-access", "other-refresh"))
+
+	if err := s.RemoveByRefresh(ctx, "refresh"); err != nil {
+		t.Fatalf("RemoveByRefresh() error = %v", err)
+	}
+
+	info, err := s.GetByRefresh(ctx, "refresh")
+	checkTokenNotFound(t, "GetByRefresh", info, err)
+
+	if _, err := s.GetByRefresh(ctx, "other-refresh"); err != nil {
+		t.Errorf("GetByRefresh() error = %v", err)
+	}
+}
+
+func testRemoveUnknown(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+	create(t, s, NewToken("code", "", ""))
+	create(t, s, NewToken("", "access", "refresh"))
+
+	if err := s.RemoveByCode(ctx, "unknown"); err != nil {
+		t.Errorf("RemoveByCode() error = %v", err)
+	}
+
+	if err := s.RemoveByAccess(ctx, "unknown"); err != nil {
+		t.Errorf("RemoveByAccess() error = %v", err)
+	}
+
+	if err := s.RemoveByRefresh(ctx, "unknown"); err != nil {
+		t.Errorf("RemoveByRefresh() error = %v", err)
+	}
+
+	if _, err := s.GetByCode(ctx, "code"); err != nil {
+		t.Errorf("GetByCode() error = %v", err)
+	}
+
+	if _, err := s.GetByAccess(ctx, "access"); err != nil {
+		t.Errorf("GetByAccess() error = %v", err)
+	}
+}
+
+// testGetExpired checks that expired tokens are either not found, or returned
+// with their expiry, so the manager rejects them.
+func testGetExpired(t *testing.T, s oauth2.TokenStore) {
+	token := NewToken("", "access", "")
+	token.AccessCreateAt = token.AccessCreateAt.Add(-2 * time.Hour)
+	create(t, s, token)
+
+	info, err := s.GetByAccess(context.Background(), "access")
+	if notFound(info == nil, err, arangostore.ErrTokenNotFound) {
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("GetByAccess() error = %v", err)
+	}
+
+	if expiresAt := info.GetAccessCreateAt().Add(info.GetAccessExpiresIn()); !expiresAt.Before(time.Now()) {
+		t.Errorf("GetByAccess() expires at %v, want expired token", expiresAt)
+	}
+}
+
+func testConcurrentAccess(t *testing.T, s oauth2.TokenStore) {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		access, refresh := fmt.Sprintf("access-%d", i), fmt.Sprintf("refresh-%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			want := NewToken("", access, refresh)
+			if err := s.Create(ctx, want); err != nil {
+				t.Errorf("Create() error = %v", err)
+				return
+			}
+
+			got, err := s.GetByAccess(ctx, access)
+			if err != nil {
+				t.Errorf("GetByAccess() error = %v", err)
+				return
+			}
+
+			if got == nil || got.GetAccess() != access {
+				t.Errorf("GetByAccess() = %v, want %v", got, want)
+			}
+
+			if err := s.RemoveByAccess(ctx, access); err != nil {
+				t.Errorf("RemoveByAccess() error = %v", err)
+				return
+			}
+
+			got, err = s.GetByAccess(ctx, access)
+			checkTokenNotFound(t, "GetByAccess", got, err)
+		}()
+	}
+
+	wg.Wait()
+}
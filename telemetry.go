@@ -0,0 +1,160 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer and meter of the stores.
+const instrumentationName = "github.com/gabor-boros/go-oauth2-arangodb"
+
+// Attributes set on the spans and metrics of the store operations. The values
+// of tokens, codes and secrets are never recorded.
+var (
+	attrDBSystem   = attribute.String("db.system", "arangodb")
+	attrCollection = attribute.Key("db.collection.name")
+	attrOperation  = attribute.Key("db.operation.name")
+	attrHit        = attribute.Key("arangostore.hit")
+)
+
+// telemetry records a span, the duration and the errors of every operation of
+// a store.
+type telemetry struct {
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+// newTelemetry returns the telemetry using the given providers. The global
+// providers are used if a provider is nil.
+func newTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*telemetry, error) {
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+
+	duration, err := meter.Float64Histogram(
+		"arangostore.operation.duration",
+		metric.WithDescription("Duration of the store operations."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	errs, err := meter.Int64Counter(
+		"arangostore.operation.errors",
+		metric.WithDescription("Number of store operations that failed."),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &telemetry{
+		tracer:   tracerProvider.Tracer(instrumentationName),
+		duration: duration,
+		errors:   errs,
+	}, nil
+}
+
+// operationKey is the context key of the operation in progress.
+type operationKey struct{}
+
+// operation is a store operation in progress. The methods of a nil operation
+// do nothing, hence stores without telemetry need no special casing.
+type operation struct {
+	telemetry *telemetry
+	span      trace.Span
+	start     time.Time
+	attrs     []attribute.KeyValue
+	hit       *bool
+}
+
+// start starts recording the operation of the given collection. The returned
+// context holds the span of the operation, and must be passed to the calls
+// made by the operation.
+func (t *telemetry) start(ctx context.Context, name string, collection string) (context.Context, *operation) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	attrs := []attribute.KeyValue{
+		attrDBSystem,
+		attrCollection.String(collection),
+		attrOperation.String(name),
+	}
+
+	ctx, span := t.tracer.Start(ctx, name+" "+collection,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	op := &operation{
+		telemetry: t,
+		span:      span,
+		start:     time.Now(),
+		attrs:     attrs,
+	}
+
+	return context.WithValue(ctx, operationKey{}, op), op
+}
+
+// operationFromContext returns the operation in progress, if any.
+func operationFromContext(ctx context.Context) *operation {
+	op, _ := ctx.Value(operationKey{}).(*operation)
+	return op
+}
+
+// setHit records whether the lookup of the operation found what it looked
+// for.
+func (o *operation) setHit(hit bool) {
+	if o == nil {
+		return
+	}
+
+	o.hit = &hit
+}
+
+// end ends the operation, failed with the given error if not nil. Lookups
+// that found nothing are misses rather than failures.
+func (o *operation) end(err *error) {
+	if o == nil {
+		return
+	}
+
+	ctx := trace.ContextWithSpan(context.Background(), o.span)
+
+	notFound := errors.Is(*err, ErrTokenNotFound) || errors.Is(*err, ErrClientNotFound)
+	if notFound {
+		o.setHit(false)
+	}
+
+	attrs := o.attrs
+	if o.hit != nil {
+		attrs = append(attrs, attrHit.Bool(*o.hit))
+		o.span.SetAttributes(attrHit.Bool(*o.hit))
+	}
+
+	o.telemetry.duration.Record(ctx, time.Since(o.start).Seconds(), metric.WithAttributes(attrs...))
+
+	if *err != nil && !notFound {
+		o.span.RecordError(*err)
+		o.span.SetStatus(codes.Error, (*err).Error())
+		o.telemetry.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+
+	o.span.End()
+}
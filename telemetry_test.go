@@ -0,0 +1,235 @@
+package arangostore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// collectMetrics returns the data points of the duration histogram and the
+// error counter.
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) ([]metricdata.HistogramDataPoint[float64], []metricdata.DataPoint[int64]) {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	var durations []metricdata.HistogramDataPoint[float64]
+	var errs []metricdata.DataPoint[int64]
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				durations = append(durations, data.DataPoints...)
+			case metricdata.Sum[int64]:
+				errs = append(errs, data.DataPoints...)
+			}
+		}
+	}
+
+	return durations, errs
+}
+
+func hasValue(attrs []attribute.KeyValue, value string) bool {
+	for _, attr := range attrs {
+		if strings.Contains(attr.Value.Emit(), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestTokenStore_Telemetry(t *testing.T) {
+	access := "test-access-token"
+	query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
+	bindVars := map[string]any{
+		"@collection":  DefaultTokenStoreCollection,
+		"access_token": access,
+	}
+
+	tests := []struct {
+		name       string
+		db         func() driver.Database
+		wantHit    attribute.Value
+		wantStatus codes.Code
+		wantErrs   int64
+	}{
+		{
+			name: "record hit",
+			db: func() driver.Database {
+				data, err := json.Marshal(&models.Token{Access: access})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cursor := new(MockArangoCursor)
+				cursor.On("Close").Return(nil)
+				cursor.On("HasMore").Return(true, nil).Once()
+				cursor.On("HasMore").Return(false, nil).Once()
+				cursor.On("ReadDocument", mock.Anything, mock.Anything).Return(&TokenStoreItem{Access: access, Data: data}, driver.DocumentMeta{}, nil)
+
+				db := new(MockArangoDB)
+				db.On("Query", mock.Anything, query, bindVars).Return(cursor, nil)
+
+				return db
+			},
+			wantHit: attribute.BoolValue(true),
+		},
+		{
+			name: "record miss",
+			db: func() driver.Database {
+				cursor := new(MockArangoCursor)
+				cursor.On("Close").Return(nil)
+				cursor.On("HasMore").Return(false, nil)
+
+				db := new(MockArangoDB)
+				db.On("Query", mock.Anything, query, bindVars).Return(cursor, nil)
+
+				return db
+			},
+			wantHit: attribute.BoolValue(false),
+		},
+		{
+			name: "record error",
+			db: func() driver.Database {
+				db := new(MockArangoDB)
+				db.On("Query", mock.Anything, query, bindVars).Return(nil, fmt.Errorf("error"))
+
+				return db
+			},
+			wantStatus: codes.Error,
+			wantErrs:   1,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			recorder := tracetest.NewSpanRecorder()
+			reader := sdkmetric.NewManualReader()
+
+			s, err := NewTokenStore(
+				WithTokenStoreDatabase(tt.db()),
+				WithTokenStoreTelemetry(
+					sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+					sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+				),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _ = s.GetByAccess(context.Background(), access)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("GetByAccess() spans = %v, want 1", len(spans))
+			}
+
+			span := spans[0]
+			if want := "GetByAccess " + DefaultTokenStoreCollection; span.Name() != want {
+				t.Errorf("GetByAccess() span name = %v, want %v", span.Name(), want)
+			}
+
+			attrs := attribute.NewSet(span.Attributes()...)
+			if got, _ := attrs.Value(attrHit); got != tt.wantHit {
+				t.Errorf("GetByAccess() hit = %v, want %v", got, tt.wantHit)
+			}
+
+			if got, _ := attrs.Value(attrOperation); got.AsString() != "GetByAccess" {
+				t.Errorf("GetByAccess() operation = %v, want %v", got, "GetByAccess")
+			}
+
+			if got, _ := attrs.Value(attrCollection); got.AsString() != DefaultTokenStoreCollection {
+				t.Errorf("GetByAccess() collection = %v, want %v", got, DefaultTokenStoreCollection)
+			}
+
+			if span.Status().Code != tt.wantStatus {
+				t.Errorf("GetByAccess() status = %v, want %v", span.Status().Code, tt.wantStatus)
+			}
+
+			if hasValue(span.Attributes(), access) {
+				t.Errorf("GetByAccess() span attributes = %v, must not contain the token", span.Attributes())
+			}
+
+			durations, errs := collectMetrics(t, reader)
+			if len(durations) != 1 || durations[0].Count != 1 {
+				t.Errorf("GetByAccess() durations = %v, want 1 measurement", durations)
+			}
+
+			for _, dp := range durations {
+				if hasValue(dp.Attributes.ToSlice(), access) {
+					t.Errorf("GetByAccess() duration attributes = %v, must not contain the token", dp.Attributes.ToSlice())
+				}
+			}
+
+			var gotErrs int64
+			for _, dp := range errs {
+				gotErrs += dp.Value
+			}
+
+			if gotErrs != tt.wantErrs {
+				t.Errorf("GetByAccess() errors = %v, want %v", gotErrs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestClientStore_Telemetry(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+
+	coll := new(MockArangoCollection)
+	coll.On("ReadDocument", mock.Anything, "client-id", mock.Anything).Return(&ClientStoreItem{
+		Key:  "client-id",
+		Data: json.RawMessage(`"eyJJRCI6ImNsaWVudC1pZCJ9"`),
+	}, driver.DocumentMeta{}, nil)
+
+	db := new(MockArangoDB)
+	db.On("Collection", mock.Anything, DefaultClientStoreCollection).Return(coll, nil)
+
+	s, err := NewClientStore(
+		WithClientStoreDatabase(db),
+		WithClientStoreTelemetry(
+			sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+			sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByID(context.Background(), "client-id"); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("GetByID() spans = %v, want 1", len(spans))
+	}
+
+	attrs := attribute.NewSet(spans[0].Attributes()...)
+	if got, _ := attrs.Value(attrHit); !got.AsBool() {
+		t.Errorf("GetByID() hit = %v, want %v", got, true)
+	}
+
+	durations, _ := collectMetrics(t, reader)
+	if len(durations) != 1 || durations[0].Count != 1 {
+		t.Errorf("GetByID() durations = %v, want 1 measurement", durations)
+	}
+}
@@ -0,0 +1,186 @@
+package arangostore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	arangoDriver "github.com/arangodb/go-driver"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+)
+
+// RotatedToken is the token information returned by GetByRefresh when the
+// TokenStore is configured to rotate refresh tokens. It remembers the token it
+// was loaded from, so the token created from it is linked to its parent.
+type RotatedToken struct {
+	*models.Token
+	key      string
+	familyID string
+	refresh  string
+	code     string
+}
+
+// FamilyID returns the ID of the family the token belongs to.
+func (t *RotatedToken) FamilyID() string {
+	return t.familyID
+}
+
+func (t *RotatedToken) source() (string, string) {
+	return t.key, t.refresh
+}
+
+func (t *RotatedToken) codeKey() string {
+	return t.code
+}
+
+// rotated reports whether the refresh token was replaced since the token was
+// loaded.
+func (t *RotatedToken) rotated() bool {
+	return t.GetRefresh() != t.refresh
+}
+
+// newFamilyID returns a random token family ID.
+func newFamilyID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// link sets the family of the document and its parent, if it was created from
+// another token.
+func (s *TokenStore) link(doc *TokenStoreItem, parent *RotatedToken) error {
+	if parent != nil {
+		doc.FamilyID = parent.familyID
+		doc.ParentKey = parent.key
+	}
+
+	// Tokens created from documents stored before the rotation was enabled
+	// start a new family.
+	if doc.FamilyID == "" {
+		id, err := newFamilyID()
+		if err != nil {
+			return err
+		}
+
+		doc.FamilyID = id
+	}
+
+	return nil
+}
+
+// createTombstone keeps the rotated refresh token of the parent for the
+// tombstone window, so its reuse can be detected.
+func (s *TokenStore) createTombstone(ctx context.Context, coll arangoDriver.Collection, doc *TokenStoreItem, parent *RotatedToken) error {
+	now := s.now().UTC().Truncate(time.Millisecond)
+
+	tombstone := TokenStoreItem{
+		RotatedRefresh: s.hashToken(parent.refresh),
+		FamilyID:       doc.FamilyID,
+		ParentKey:      parent.key,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.tombstoneWindow),
+	}
+
+	_, err := coll.CreateDocument(ctx, tombstone)
+
+	return err
+}
+
+// getRotatedByRefresh returns the token by its refresh token, remembering its
+// lineage. If detect is set and the refresh token was rotated already, the
+// family of the token is revoked.
+func (s *TokenStore) getRotatedByRefresh(ctx context.Context, refresh string, detect bool) (oauth2.TokenInfo, error) {
+	doc, err := s.findByField(ctx, "refresh_token", refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	operationFromContext(ctx).setHit(doc != nil)
+
+	if doc == nil {
+		if !detect {
+			return s.notFound()
+		}
+
+		if err := s.detectReuse(ctx, refresh); err != nil {
+			return nil, err
+		}
+
+		return s.notFound()
+	}
+
+	info, err := s.tokenFromDoc(doc, "refresh_token", refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RotatedToken{
+		Token:    info,
+		key:      doc.Key,
+		familyID: doc.FamilyID,
+		refresh:  refresh,
+		code:     doc.CodeKey,
+	}, nil
+}
+
+// detectReuse returns ErrRefreshTokenReuse and revokes the family of the
+// refresh token if it is a tombstone.
+func (s *TokenStore) detectReuse(ctx context.Context, refresh string) error {
+	// An empty value would match every document not holding such a token.
+	if refresh == "" {
+		return nil
+	}
+
+	query := "FOR doc IN @@collection FILTER doc.rotated_refresh_token == @rotated_refresh_token" +
+		" FILTER DATE_TIMESTAMP(doc.expires_at) > @now RETURN doc"
+	bindVars := map[string]any{
+		"@collection":           s.collection,
+		"rotated_refresh_token": s.hashToken(refresh),
+		"now":                   s.now().UnixMilli(),
+	}
+
+	var tombstone *TokenStoreItem
+	err := s.retry.do(ctx, func() (err error) {
+		tombstone, err = s.findByQuery(ctx, query, bindVars)
+		return err
+	})
+	if err != nil || tombstone == nil {
+		return err
+	}
+
+	if err := s.RevokeFamily(ctx, tombstone.FamilyID); err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReuse
+}
+
+// RevokeFamily deletes every token of the family. The tombstones of the
+// family are kept, so the reuse of its rotated refresh tokens is still
+// detected.
+func (s *TokenStore) RevokeFamily(ctx context.Context, familyID string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RevokeFamily", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RevokeFamily", s.collection, &err)
+
+	// An empty value would match every document not belonging to a family.
+	if familyID == "" {
+		return nil
+	}
+
+	query := "FOR doc IN @@collection FILTER doc.family_id == @family_id" +
+		" FILTER doc.rotated_refresh_token == null REMOVE doc IN @@collection"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		"family_id":   familyID,
+	}
+
+	return s.removeByQuery(ctx, query, bindVars)
+}
@@ -0,0 +1,286 @@
+package arangostore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arangodb/go-driver"
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/models"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTokenStore_GetByRefresh_Rotation(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	type fields struct {
+		db func(ctx context.Context, refresh string) driver.Database
+	}
+	type args struct {
+		ctx     context.Context
+		refresh string
+	}
+	tests := []struct {
+		name      string
+		fields    fields
+		args      args
+		want      oauth2.TokenInfo
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "get token by refresh token with lineage",
+			fields: fields{
+				db: func(ctx context.Context, refresh string) driver.Database {
+					data, err := json.Marshal(&models.Token{Access: "test-access-token", Refresh: refresh})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:      "parent-key",
+						Refresh:  refresh,
+						FamilyID: "family-id",
+						Data:     data,
+					}, driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token FILTER doc.rotated_refresh_token == null RETURN doc", mock.Anything).Return(cursor, nil)
+
+					return db
+				},
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			want: &RotatedToken{
+				Token:    &models.Token{Access: "test-access-token", Refresh: "test-refresh-token"},
+				key:      "parent-key",
+				familyID: "family-id",
+				refresh:  "test-refresh-token",
+			},
+		},
+		{
+			name: "get token by reused refresh token",
+			fields: fields{
+				db: func(ctx context.Context, refresh string) driver.Database {
+					notFound := new(MockArangoCursor)
+					notFound.On("Close").Return(nil)
+					notFound.On("HasMore").Return(false)
+
+					tombstone := new(MockArangoCursor)
+					tombstone.On("Close").Return(nil)
+					tombstone.On("HasMore").Return(true).Once()
+					tombstone.On("HasMore").Return(false).Once()
+					tombstone.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:            "tombstone-key",
+						RotatedRefresh: refresh,
+						FamilyID:       "family-id",
+					}, driver.DocumentMeta{}, nil)
+
+					revoked := new(MockArangoCursor)
+					revoked.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token FILTER doc.rotated_refresh_token == null RETURN doc", mock.Anything).Return(notFound, nil)
+					db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.rotated_refresh_token == @rotated_refresh_token FILTER DATE_TIMESTAMP(doc.expires_at) > @now RETURN doc", map[string]any{
+						"@collection":           DefaultTokenStoreCollection,
+						"rotated_refresh_token": refresh,
+						"now":                   now.UnixMilli(),
+					}).Return(tombstone, nil)
+					db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.family_id == @family_id FILTER doc.rotated_refresh_token == null REMOVE doc IN @@collection", map[string]any{
+						"@collection": DefaultTokenStoreCollection,
+						"family_id":   "family-id",
+					}).Return(revoked, nil).Once()
+
+					return db
+				},
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			wantErr:   true,
+			wantErrIs: ErrRefreshTokenReuse,
+		},
+		{
+			name: "get token by unknown refresh token",
+			fields: fields{
+				db: func(ctx context.Context, refresh string) driver.Database {
+					notFound := new(MockArangoCursor)
+					notFound.On("Close").Return(nil)
+					notFound.On("HasMore").Return(false)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(notFound, nil)
+
+					return db
+				},
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			wantErr:   true,
+			wantErrIs: ErrTokenNotFound,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:              tt.fields.db(tt.args.ctx, tt.args.refresh),
+				collection:      DefaultTokenStoreCollection,
+				clock:           func() time.Time { return now },
+				tombstoneWindow: time.Hour,
+			}
+			got, err := s.GetByRefresh(tt.args.ctx, tt.args.refresh)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetByRefresh() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("GetByRefresh() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetByRefresh() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_FindByRefresh_Rotation(t *testing.T) {
+	ctx := context.Background()
+
+	notFound := new(MockArangoCursor)
+	notFound.On("Close").Return(nil)
+	notFound.On("HasMore").Return(false)
+
+	// The tombstones are not looked up, so the family is not revoked.
+	db := new(MockArangoDB)
+	db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token FILTER doc.rotated_refresh_token == null RETURN doc", map[string]any{
+		"@collection":   DefaultTokenStoreCollection,
+		"refresh_token": "rotated-refresh-token",
+	}).Return(notFound, nil).Once()
+
+	s := &TokenStore{
+		db:              db,
+		collection:      DefaultTokenStoreCollection,
+		tombstoneWindow: time.Hour,
+	}
+
+	if _, err := s.FindByRefresh(ctx, "rotated-refresh-token"); !errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("FindByRefresh() error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	db.AssertExpectations(t)
+}
+
+func TestTokenStore_Create_Rotation(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		info          func() oauth2.TokenInfo
+		wantFamily    string
+		wantParent    string
+		wantTombstone bool
+	}{
+		{
+			name: "create token starting a family",
+			info: func() oauth2.TokenInfo {
+				return &models.Token{Access: "test-access-token", Refresh: "test-refresh-token"}
+			},
+		},
+		{
+			name: "create token from rotated refresh token",
+			info: func() oauth2.TokenInfo {
+				parent := &RotatedToken{
+					Token:    &models.Token{Access: "old-access-token", Refresh: "old-refresh-token"},
+					key:      "parent-key",
+					familyID: "family-id",
+					refresh:  "old-refresh-token",
+				}
+				parent.SetAccess("new-access-token")
+				parent.SetRefresh("new-refresh-token")
+
+				return parent
+			},
+			wantFamily:    "family-id",
+			wantParent:    "parent-key",
+			wantTombstone: true,
+		},
+		{
+			name: "create token from kept refresh token",
+			info: func() oauth2.TokenInfo {
+				parent := &RotatedToken{
+					Token:    &models.Token{Access: "old-access-token", Refresh: "old-refresh-token"},
+					key:      "parent-key",
+					familyID: "family-id",
+					refresh:  "old-refresh-token",
+				}
+				parent.SetAccess("new-access-token")
+
+				return parent
+			},
+			wantFamily: "family-id",
+			wantParent: "parent-key",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			coll := new(MockArangoCollection)
+			coll.On("CreateDocument", ctx, mock.MatchedBy(func(doc TokenStoreItem) bool {
+				return doc.RotatedRefresh == "" &&
+					doc.FamilyID != "" &&
+					(tt.wantFamily == "" || doc.FamilyID == tt.wantFamily) &&
+					doc.ParentKey == tt.wantParent
+			})).Return(driver.DocumentMeta{}, nil).Once()
+
+			if tt.wantTombstone {
+				coll.On("CreateDocument", ctx, mock.MatchedBy(func(doc TokenStoreItem) bool {
+					return doc.RotatedRefresh == "old-refresh-token" &&
+						doc.Refresh == "" &&
+						doc.FamilyID == tt.wantFamily &&
+						doc.ParentKey == tt.wantParent &&
+						doc.CreatedAt == now &&
+						doc.ExpiresAt == now.Add(time.Hour)
+				})).Return(driver.DocumentMeta{}, nil).Once()
+			}
+
+			db := new(MockArangoDB)
+			db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+
+			clock := func() time.Time {
+				return now.In(time.FixedZone("CEST", 2*60*60)).Add(time.Microsecond)
+			}
+
+			s := &TokenStore{
+				db:              db,
+				collection:      DefaultTokenStoreCollection,
+				clock:           clock,
+				tombstoneWindow: time.Hour,
+			}
+			if err := s.Create(ctx, tt.info()); err != nil {
+				t.Errorf("Create() error = %v", err)
+			}
+
+			coll.AssertExpectations(t)
+		})
+	}
+}
@@ -2,12 +2,17 @@ package arangostore
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 
 	arangoDriver "github.com/arangodb/go-driver"
 	"github.com/go-oauth2/oauth2/v4"
 	"github.com/go-oauth2/oauth2/v4/models"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -44,62 +49,669 @@ func WithTokenStoreDatabase(db arangoDriver.Database) TokenStoreOption {
 	}
 }
 
+// WithTokenStoreEnsureSchema configures the TokenStore to create its
+// collection and the indexes used by the lookups on construction if they are
+// missing. If report is not nil, it is populated with the changes made.
+func WithTokenStoreEnsureSchema(report *SchemaReport) TokenStoreOption {
+	return func(s *TokenStore) error {
+		s.ensureSchema = true
+		s.schemaReport = report
+
+		return nil
+	}
+}
+
+// WithTokenStoreTTL configures the TokenStore to ensure a TTL index on the
+// expires_at field on construction, so ArangoDB removes expired tokens by
+// itself. Documents are removed once they are expired for longer than the
+// given grace period.
+func WithTokenStoreTTL(grace time.Duration) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if grace < 0 {
+			return ErrInvalidGracePeriod
+		}
+
+		s.ttl = true
+		s.ttlGrace = grace
+
+		return nil
+	}
+}
+
+// WithTokenStoreNotFoundAsNil configures the TokenStore to return a nil token
+// and a nil error instead of ErrTokenNotFound when a lookup matches nothing.
+// This is the behavior the go-oauth2 manager expects to report invalid or
+// expired tokens to the clients.
+func WithTokenStoreNotFoundAsNil() TokenStoreOption {
+	return func(s *TokenStore) error {
+		s.notFoundAsNil = true
+
+		return nil
+	}
+}
+
+// WithTokenStoreRejectExpired configures the TokenStore to filter expired
+// tokens in the lookup queries, so expired tokens are never returned.
+func WithTokenStoreRejectExpired() TokenStoreOption {
+	return func(s *TokenStore) error {
+		s.rejectExpired = true
+
+		return nil
+	}
+}
+
+// WithTokenStoreClock configures the clock used by the TokenStore to decide
+// whether a token is expired. Defaults to time.Now.
+func WithTokenStoreClock(clock func() time.Time) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if clock == nil {
+			return ErrNoClock
+		}
+
+		s.clock = clock
+
+		return nil
+	}
+}
+
+// WithTokenStoreTimeout configures the TokenStore to cancel every operation
+// after the given timeout, unless the context passed to it has a deadline
+// already. The operations of a transaction share the timeout of the
+// transaction.
+func WithTokenStoreTimeout(timeout time.Duration) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if timeout <= 0 {
+			return ErrInvalidTimeout
+		}
+
+		s.timeout = timeout
+
+		return nil
+	}
+}
+
+// WithTokenStoreRetry configures the TokenStore to retry the operations that
+// are safe to repeat when they fail with a transient error, according to the
+// given policy.
+func WithTokenStoreRetry(policy RetryPolicy) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+
+		s.retry = &policy
+
+		return nil
+	}
+}
+
+// WithTokenStoreTelemetry configures the TokenStore to record an OpenTelemetry
+// span, the duration and the errors of every operation. The spans and metrics
+// tell the operation, the collection and whether a lookup found anything, but
+// never the values looked up. The global providers are used if a provider is
+// nil.
+func WithTokenStoreTelemetry(tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) TokenStoreOption {
+	return func(s *TokenStore) error {
+		t, err := newTelemetry(tracerProvider, meterProvider)
+		if err != nil {
+			return err
+		}
+
+		s.telemetry = t
+
+		return nil
+	}
+}
+
+// WithTokenStoreHashKey configures the TokenStore to persist only the
+// HMAC-SHA256 hash of the authorization codes, access and refresh tokens,
+// using the given key. The lookups hash the presented value and the returned
+// token holds the value it was looked up by, though the other values of the
+// token, like the refresh token of a token looked up by its access token,
+// cannot be recovered and are empty.
+func WithTokenStoreHashKey(key []byte) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if len(key) == 0 {
+			return ErrNoHashKey
+		}
+
+		s.hashKey = key
+
+		return nil
+	}
+}
+
+// WithTokenStoreEncryptor configures the TokenStore to encrypt the data of the
+// tokens using the given encryptor. Tokens stored before encryption was
+// enabled can still be read and encrypted using Reencrypt.
+func WithTokenStoreEncryptor(enc Encryptor) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if enc == nil {
+			return ErrNoEncryptor
+		}
+
+		s.encryptor = enc
+
+		return nil
+	}
+}
+
+// WithTokenStoreDataAsObject configures the TokenStore to store the data of
+// the tokens as nested JSON object instead of a base64 encoded string, so it
+// can be inspected and queried. Both forms are read regardless of the option.
+// Encrypted data is always stored as string.
+func WithTokenStoreDataAsObject() TokenStoreOption {
+	return func(s *TokenStore) error {
+		s.dataAsObject = true
+
+		return nil
+	}
+}
+
+// WithTokenStoreRefreshRotation configures the TokenStore to track the
+// lineage of refresh tokens and detect the reuse of rotated refresh tokens.
+//
+// Every token holding a refresh token belongs to a family, and tokens created
+// from a refresh token returned by GetByRefresh join the family of their
+// parent. When the refresh token is rotated, the old refresh token is kept as
+// a tombstone for the given window. Presenting a tombstoned refresh token
+// revokes every token of the family and makes GetByRefresh return
+// ErrRefreshTokenReuse.
+func WithTokenStoreRefreshRotation(window time.Duration) TokenStoreOption {
+	return func(s *TokenStore) error {
+		if window <= 0 {
+			return ErrInvalidTombstoneWindow
+		}
+
+		s.tombstoneWindow = window
+
+		return nil
+	}
+}
+
+// WithTokenStoreCodeReuseRevocation configures ConsumeByCode to keep consumed
+// authorization codes until they expire instead of removing them. Consuming a
+// code again revokes the tokens issued from it, as recommended by RFC 6749,
+// section 4.1.2, and makes ConsumeByCode return ErrCodeReuse.
+func WithTokenStoreCodeReuseRevocation() TokenStoreOption {
+	return func(s *TokenStore) error {
+		s.revokeCodeReuse = true
+
+		return nil
+	}
+}
+
 // TokenStoreItem data item
 type TokenStoreItem struct {
-	Key       string    `json:"_key,omitempty"`
-	Code      string    `json:"code"`
-	Access    string    `json:"access_token"`
-	Refresh   string    `json:"refresh_token"`
-	Data      []byte    `json:"data"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Key              string          `json:"_key,omitempty"`
+	Code             string          `json:"code"`
+	Access           string          `json:"access_token"`
+	Refresh          string          `json:"refresh_token"`
+	RotatedRefresh   string          `json:"rotated_refresh_token,omitempty"`
+	FamilyID         string          `json:"family_id,omitempty"`
+	ParentKey        string          `json:"parent_key,omitempty"`
+	CodeKey          string          `json:"code_key,omitempty"`
+	ConsumedAt       time.Time       `json:"consumed_at"`
+	Issued           bool            `json:"issued,omitempty"`
+	UserID           string          `json:"user_id"`
+	ClientID         string          `json:"client_id"`
+	Data             json.RawMessage `json:"data"`
+	KeyID            string          `json:"key_id,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	ExpiresAt        time.Time       `json:"expires_at"`
+	CodeExpiresAt    time.Time       `json:"code_expires_at"`
+	AccessExpiresAt  time.Time       `json:"access_expires_at"`
+	RefreshExpiresAt time.Time       `json:"refresh_expires_at"`
+}
+
+// MarshalJSON implements json.Marshaler. Zero expiries are stored as null,
+// hence documents that never expire are ignored by the TTL index.
+func (i TokenStoreItem) MarshalJSON() ([]byte, error) {
+	type item TokenStoreItem
+
+	return json.Marshal(struct {
+		item
+		ExpiresAt        *time.Time `json:"expires_at"`
+		CodeExpiresAt    *time.Time `json:"code_expires_at"`
+		AccessExpiresAt  *time.Time `json:"access_expires_at"`
+		RefreshExpiresAt *time.Time `json:"refresh_expires_at"`
+		ConsumedAt       *time.Time `json:"consumed_at,omitempty"`
+	}{
+		item:             item(i),
+		ExpiresAt:        nullTime(i.ExpiresAt),
+		CodeExpiresAt:    nullTime(i.CodeExpiresAt),
+		AccessExpiresAt:  nullTime(i.AccessExpiresAt),
+		RefreshExpiresAt: nullTime(i.RefreshExpiresAt),
+		ConsumedAt:       nullTime(i.ConsumedAt),
+	})
+}
+
+// nullTime returns nil for the zero time, otherwise a pointer to t.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// expiresAt returns the expiry of a token in a form the TTL index can
+// process. A zero time is returned if the token never expires.
+func expiresAt(createdAt time.Time, expiresIn time.Duration) time.Time {
+	if expiresIn <= 0 {
+		return time.Time{}
+	}
+
+	return createdAt.Add(expiresIn).UTC().Truncate(time.Millisecond)
+}
+
+// laterExpiry returns the later of two expiries, where zero means the token
+// never expires.
+func laterExpiry(a, b time.Time) time.Time {
+	if a.IsZero() || b.IsZero() {
+		return time.Time{}
+	}
+
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// setExpiries sets the expiry of every token held by the item and the expiry
+// of the item itself, which is the latest expiry of its tokens.
+func setExpiries(doc *TokenStoreItem, info oauth2.TokenInfo) {
+	if info.GetCode() != "" {
+		// Authorization codes without expiry are considered expired right away.
+		doc.CodeExpiresAt = info.GetCodeCreateAt().Add(info.GetCodeExpiresIn()).UTC().Truncate(time.Millisecond)
+		doc.ExpiresAt = doc.CodeExpiresAt
+
+		return
+	}
+
+	hasAccess, hasRefresh := info.GetAccess() != "", info.GetRefresh() != ""
+
+	if hasAccess {
+		doc.AccessExpiresAt = expiresAt(info.GetAccessCreateAt(), info.GetAccessExpiresIn())
+		doc.ExpiresAt = doc.AccessExpiresAt
+	}
+
+	if hasRefresh {
+		doc.RefreshExpiresAt = expiresAt(info.GetRefreshCreateAt(), info.GetRefreshExpiresIn())
+		doc.ExpiresAt = doc.RefreshExpiresAt
+	}
+
+	if hasAccess && hasRefresh {
+		doc.ExpiresAt = laterExpiry(doc.AccessExpiresAt, doc.RefreshExpiresAt)
+	}
 }
 
 // TokenStore is a data struct that stores oauth2 token information.
 type TokenStore struct {
-	db         arangoDriver.Database
-	collection string
+	db              arangoDriver.Database
+	collection      string
+	ensureSchema    bool
+	schemaReport    *SchemaReport
+	ttl             bool
+	ttlGrace        time.Duration
+	notFoundAsNil   bool
+	rejectExpired   bool
+	clock           func() time.Time
+	hashKey         []byte
+	encryptor       Encryptor
+	dataAsObject    bool
+	tombstoneWindow time.Duration
+	revokeCodeReuse bool
+	timeout         time.Duration
+	retry           *RetryPolicy
+	telemetry       *telemetry
 }
 
-func (s *TokenStore) getByQuery(ctx context.Context, query string, bindVars map[string]any) (oauth2.TokenInfo, error) {
-	cursor, err := s.db.Query(ctx, query, bindVars)
+func (s *TokenStore) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+
+	return time.Now()
+}
+
+// tokenExpiryFields maps the token fields to the fields holding their expiry.
+var tokenExpiryFields = map[string]string{
+	"code":          "code_expires_at",
+	"access_token":  "access_expires_at",
+	"refresh_token": "refresh_expires_at",
+}
+
+// tokenStoreIndexes lists the fields the TokenStore queries by.
+var tokenStoreIndexes = []string{
+	"code",
+	"access_token",
+	"refresh_token",
+	"user_id",
+	"client_id",
+	"family_id",
+	"rotated_refresh_token",
+}
+
+// EnsureSchema creates the collection of the store and the indexes used by
+// the lookups if they do not exist yet. It is safe to call multiple times.
+func (s *TokenStore) EnsureSchema(ctx context.Context) (_ *SchemaReport, err error) {
+	ctx, op := s.telemetry.start(ctx, "EnsureSchema", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("EnsureSchema", s.collection, &err)
+
+	report := new(SchemaReport)
+
+	coll, err := ensureCollection(ctx, s.db, s.collection, report)
 	if err != nil {
 		return nil, err
 	}
-	defer func(cursor arangoDriver.Cursor) {
-		if err := cursor.Close(); err != nil {
-			panic(err)
+
+	for _, field := range tokenStoreIndexes {
+		if err := ensurePersistentIndex(ctx, coll, field, report); err != nil {
+			return nil, err
 		}
-	}(cursor)
+	}
+
+	if s.ttl {
+		if err := s.ensureTTLIndex(ctx, coll, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+func (s *TokenStore) ensureTTLIndex(ctx context.Context, coll arangoDriver.Collection, report *SchemaReport) error {
+	return ensureTTLIndex(ctx, coll, "expires_at", int(s.ttlGrace.Seconds()), report)
+}
+
+// findByQuery returns the document matching the query, or nil if there is
+// none.
+func (s *TokenStore) findByQuery(ctx context.Context, query string, bindVars map[string]any) (_ *TokenStoreItem, err error) {
+	cursor, err := s.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCursor(cursor, &err)
 
 	var doc TokenStoreItem
+	var found bool
 	for cursor.HasMore() {
 		_, err := cursor.ReadDocument(ctx, &doc)
 		if err != nil {
 			return nil, err
 		}
+
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &doc, nil
+}
+
+// notFound returns the result of a lookup that matched nothing.
+func (s *TokenStore) notFound() (oauth2.TokenInfo, error) {
+	if s.notFoundAsNil {
+		return nil, nil
+	}
+
+	return nil, ErrTokenNotFound
+}
+
+// decodeToken returns the token information stored in the document.
+func (s *TokenStore) decodeToken(doc *TokenStoreItem) (*models.Token, error) {
+	data, err := decodeData(doc.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = decryptData(s.encryptor, doc.KeyID, data)
+	if err != nil {
+		return nil, err
 	}
 
 	var info models.Token
-	if err = json.Unmarshal(doc.Data, &info); err != nil {
+	if err = json.Unmarshal(data, &info); err != nil {
 		return nil, err
 	}
 
 	return &info, nil
 }
 
-func (s *TokenStore) removeByQuery(ctx context.Context, query string, bindVars map[string]any) error {
-	cursor, err := s.db.Query(ctx, query, bindVars)
-	if err != nil {
+// hashToken returns the value to store for the given token value. If the
+// store is configured to hash tokens, the keyed hash of the value is returned.
+func (s *TokenStore) hashToken(value string) string {
+	if s.hashKey == nil || value == "" {
+		return value
+	}
+
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// copyToken returns a copy of the token information.
+func copyToken(info oauth2.TokenInfo) *models.Token {
+	return &models.Token{
+		ClientID:            info.GetClientID(),
+		UserID:              info.GetUserID(),
+		RedirectURI:         info.GetRedirectURI(),
+		Scope:               info.GetScope(),
+		Code:                info.GetCode(),
+		CodeChallenge:       info.GetCodeChallenge(),
+		CodeChallengeMethod: string(info.GetCodeChallengeMethod()),
+		CodeCreateAt:        info.GetCodeCreateAt(),
+		CodeExpiresIn:       info.GetCodeExpiresIn(),
+		Access:              info.GetAccess(),
+		AccessCreateAt:      info.GetAccessCreateAt(),
+		AccessExpiresIn:     info.GetAccessExpiresIn(),
+		Refresh:             info.GetRefresh(),
+		RefreshCreateAt:     info.GetRefreshCreateAt(),
+		RefreshExpiresIn:    info.GetRefreshExpiresIn(),
+	}
+}
+
+// filterByField returns the part of a query filtering the documents having the
+// given token value in the given field, along with its bind variables.
+func (s *TokenStore) filterByField(field string, value string) (string, map[string]any) {
+	query := "FOR doc IN @@collection FILTER doc." + field + " == @" + field
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		field:         s.hashToken(value),
+	}
+
+	if s.rejectExpired {
+		// Documents created before the expiry of each token was tracked
+		// separately only have the expiry of the document itself.
+		expiryField := tokenExpiryFields[field]
+		query += " LET expires_at = HAS(doc, '" + expiryField + "') ? doc." + expiryField + " : doc.expires_at" +
+			" FILTER expires_at == null OR DATE_TIMESTAMP(expires_at) > @now"
+		bindVars["now"] = s.now().UnixMilli()
+	}
+
+	return query, bindVars
+}
+
+// findByField returns the document having the given token value in the given
+// field, or nil if there is none.
+func (s *TokenStore) findByField(ctx context.Context, field string, value string) (doc *TokenStoreItem, err error) {
+	// An empty value would match an arbitrary document not holding such a
+	// token.
+	if value == "" {
+		return nil, nil
+	}
+
+	query, bindVars := s.filterByField(field, value)
+	if field == "code" && s.revokeCodeReuse {
+		// Consumed codes are only kept to detect their reuse.
+		query += " FILTER doc.consumed_at == null"
+	}
+
+	if s.tombstoneWindow != 0 {
+		// Tombstones are only kept to detect the reuse of refresh tokens.
+		query += " FILTER doc.rotated_refresh_token == null"
+	}
+
+	err = s.retry.do(ctx, func() (err error) {
+		doc, err = s.findByQuery(ctx, query+" RETURN doc", bindVars)
 		return err
+	})
+
+	return doc, err
+}
+
+// tokenFromDoc returns the token information stored in the document, which
+// was looked up by the given token value in the given field.
+func (s *TokenStore) tokenFromDoc(doc *TokenStoreItem, field string, value string) (*models.Token, error) {
+	info, err := s.decodeToken(doc)
+	if err != nil || s.hashKey == nil {
+		return info, err
+	}
+
+	// Hashed tokens are stripped from the data, restore the one we know.
+	switch field {
+	case "code":
+		info.SetCode(value)
+	case "access_token":
+		info.SetAccess(value)
+	case "refresh_token":
+		info.SetRefresh(value)
+	}
+
+	return info, nil
+}
+
+// getByField returns the token having the given value in the given field.
+func (s *TokenStore) getByField(ctx context.Context, field string, value string) (oauth2.TokenInfo, error) {
+	doc, err := s.findByField(ctx, field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	operationFromContext(ctx).setHit(doc != nil)
+
+	if doc == nil {
+		return s.notFound()
+	}
+
+	info, err := s.tokenFromDoc(doc, field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.hashKey != nil && field == "refresh_token" {
+		return &HashedToken{Token: info, key: doc.Key, refresh: value, code: doc.CodeKey}, nil
+	}
+
+	return info, nil
+}
+
+// HashedToken is the token information returned by GetByRefresh when the
+// TokenStore is configured to hash tokens. Its access token cannot be
+// recovered, so it remembers the document it was loaded from instead, and the
+// old access token is revoked when a token is created from it by a refresh.
+type HashedToken struct {
+	*models.Token
+	key     string
+	refresh string
+	code    string
+}
+
+func (t *HashedToken) source() (string, string) {
+	return t.key, t.refresh
+}
+
+func (t *HashedToken) codeKey() string {
+	return t.code
+}
+
+// sourcedToken is implemented by the tokens that remember the document and
+// the refresh token they were loaded by, and the authorization code the
+// document was issued from.
+type sourcedToken interface {
+	oauth2.TokenInfo
+	source() (key string, refresh string)
+	codeKey() string
+}
+
+// revokeSource revokes the access token of the document the token was loaded
+// from, as the manager cannot remove it by its value when tokens are hashed.
+// If the refresh token was kept by the refresh, the new document holds it,
+// hence the old document is removed entirely.
+func (s *TokenStore) revokeSource(ctx context.Context, coll arangoDriver.Collection, info sourcedToken) error {
+	key, refresh := info.source()
+	if key == "" {
+		return nil
+	}
+
+	var err error
+	if info.GetRefresh() == refresh {
+		_, err = coll.RemoveDocument(ctx, key)
+	} else {
+		_, err = coll.UpdateDocument(ctx, key, map[string]any{
+			"access_token":      "",
+			"access_expires_at": s.now().UTC().Truncate(time.Millisecond),
+		})
 	}
 
-	return cursor.Close()
+	if arangoDriver.IsNotFoundGeneral(err) {
+		return nil
+	}
+
+	return err
+}
+
+// removeByQuery runs the query removing documents. Removing the documents
+// again is harmless, hence the query is retried.
+func (s *TokenStore) removeByQuery(ctx context.Context, query string, bindVars map[string]any) error {
+	return s.retry.do(ctx, func() error {
+		cursor, err := s.db.Query(ctx, query, bindVars)
+		if err != nil {
+			return err
+		}
+
+		return cursor.Close()
+	})
 }
 
 // Create creates a new token in the store.
-func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
-	data, err := json.Marshal(info)
+func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) (err error) {
+	ctx, op := s.telemetry.start(ctx, "Create", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Create", s.collection, &err)
+
+	var data []byte
+
+	if s.hashKey != nil {
+		// The data must not reveal the tokens that are stored hashed.
+		stripped := copyToken(info)
+		stripped.Code, stripped.Access, stripped.Refresh = "", "", ""
+		data, err = json.Marshal(stripped)
+	} else {
+		data, err = json.Marshal(info)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keyID, data, err := encryptData(s.encryptor, data)
+	if err != nil {
+		return err
+	}
+
+	// Encrypted data is not JSON, hence it is always stored as string.
+	encoded, err := encodeData(data, s.dataAsObject && keyID == "")
 	if err != nil {
 		return err
 	}
@@ -110,22 +722,32 @@ func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 	}
 
 	doc := TokenStoreItem{
-		Data:      data,
-		CreatedAt: time.Now(),
+		Data:      encoded,
+		KeyID:     keyID,
+		UserID:    info.GetUserID(),
+		ClientID:  info.GetClientID(),
+		CreatedAt: s.now().UTC().Truncate(time.Millisecond),
 	}
 
 	if code := info.GetCode(); code != "" {
-		doc.Code = code
-		doc.ExpiresAt = info.GetCodeCreateAt().Add(info.GetCodeExpiresIn())
+		doc.Code = s.hashToken(code)
 	} else {
-		if access := info.GetAccess(); access != "" {
-			doc.Access = info.GetAccess()
-			doc.ExpiresAt = info.GetAccessCreateAt().Add(info.GetAccessExpiresIn())
+		doc.Access = s.hashToken(info.GetAccess())
+		doc.Refresh = s.hashToken(info.GetRefresh())
+	}
+
+	setExpiries(&doc, info)
+
+	if s.revokeCodeReuse && doc.Code == "" {
+		if err := s.linkCode(ctx, &doc, info); err != nil {
+			return err
 		}
+	}
 
-		if refresh := info.GetRefresh(); refresh != "" {
-			doc.Refresh = info.GetRefresh()
-			doc.ExpiresAt = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn())
+	parent, _ := info.(*RotatedToken)
+	if s.tombstoneWindow > 0 && doc.Refresh != "" {
+		if err := s.link(&doc, parent); err != nil {
+			return err
 		}
 	}
 
@@ -134,71 +756,503 @@ func (s *TokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
 		return err
 	}
 
+	if s.tombstoneWindow > 0 && parent != nil && parent.rotated() {
+		if err := s.createTombstone(ctx, coll, &doc, parent); err != nil {
+			return err
+		}
+	}
+
+	if source, ok := info.(sourcedToken); ok && s.hashKey != nil {
+		return s.revokeSource(ctx, coll, source)
+	}
+
 	return nil
 }
 
 // GetByCode returns the token by its authorization code.
-func (s *TokenStore) GetByCode(ctx context.Context, code string) (oauth2.TokenInfo, error) {
-	query := "FOR doc IN @@collection FILTER doc.code == @code RETURN doc"
-	bindVars := map[string]interface{}{
+func (s *TokenStore) GetByCode(ctx context.Context, code string) (_ oauth2.TokenInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "GetByCode", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("GetByCode", s.collection, &err)
+
+	return s.getByField(ctx, "code", code)
+}
+
+// ConsumeByCode returns the token by its authorization code and removes the
+// code in the same statement, so a code can be redeemed exactly once. If the
+// store is configured to revoke the tokens issued from reused codes, the code
+// is kept and marked as consumed instead, and consuming it again revokes the
+// tokens issued from it and returns ErrCodeReuse.
+func (s *TokenStore) ConsumeByCode(ctx context.Context, code string) (_ oauth2.TokenInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "ConsumeByCode", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("ConsumeByCode", s.collection, &err)
+
+	if code == "" {
+		return s.notFound()
+	}
+
+	query, bindVars := s.filterByField("code", code)
+	if s.revokeCodeReuse {
+		query += " UPDATE doc WITH { consumed_at: NOT_NULL(doc.consumed_at, @consumed_at) } IN @@collection RETURN OLD"
+		bindVars["consumed_at"] = s.now().UTC().Truncate(time.Millisecond)
+	} else {
+		query += " REMOVE doc IN @@collection RETURN OLD"
+	}
+
+	doc, err := s.findByQuery(ctx, query, bindVars)
+	if err != nil {
+		return nil, err
+	}
+
+	operationFromContext(ctx).setHit(doc != nil)
+
+	if doc == nil {
+		return s.notFound()
+	}
+
+	info, err := s.tokenFromDoc(doc, "code", code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !doc.ConsumedAt.IsZero() {
+		if err := s.revokeIssuedFrom(ctx, doc.Key); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrCodeReuse
+	}
+
+	return info, nil
+}
+
+// codeClaimWindow is the time after the consumption of an authorization code
+// within which the token issued from it is created.
+const codeClaimWindow = time.Minute
+
+// linkCode sets the authorization code the token is issued from. Tokens
+// created by a refresh inherit the code of the token they were loaded from.
+// As the manager does not pass the code on to the token it creates, other
+// tokens claim the code consumed last for the same user and client that was
+// not claimed yet. Tokens issued from codes redeemed concurrently for the same
+// user and client may therefore claim each other's code.
+func (s *TokenStore) linkCode(ctx context.Context, doc *TokenStoreItem, info oauth2.TokenInfo) error {
+	if source, ok := info.(sourcedToken); ok {
+		doc.CodeKey = source.codeKey()
+		return nil
+	}
+
+	// The code is claimed in the same statement it is looked up, so it is
+	// linked to a single token. Hence the query is not retried.
+	query := "FOR doc IN @@collection FILTER doc.client_id == @client_id FILTER doc.user_id == @user_id" +
+		" FILTER doc.consumed_at != null FILTER doc.issued != true FILTER DATE_TIMESTAMP(doc.consumed_at) >= @since" +
+		" SORT DATE_TIMESTAMP(doc.consumed_at) DESC LIMIT 1 UPDATE doc WITH { issued: true } IN @@collection RETURN NEW"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		"client_id":   info.GetClientID(),
+		"user_id":     info.GetUserID(),
+		"since":       s.now().Add(-codeClaimWindow).UnixMilli(),
+	}
+
+	code, err := s.findByQuery(ctx, query, bindVars)
+	if err != nil || code == nil {
+		return err
+	}
+
+	doc.CodeKey = code.Key
+
+	return nil
+}
+
+// revokeIssuedFrom deletes the access and refresh tokens issued from the
+// authorization code with the given document key.
+func (s *TokenStore) revokeIssuedFrom(ctx context.Context, codeKey string) error {
+	query := "FOR doc IN @@collection FILTER doc.code_key == @code_key REMOVE doc IN @@collection"
+	bindVars := map[string]any{
 		"@collection": s.collection,
-		"code":        code,
+		"code_key":    codeKey,
 	}
 
-	return s.getByQuery(ctx, query, bindVars)
+	return s.removeByQuery(ctx, query, bindVars)
 }
 
 // GetByAccess returns the token by its access token.
-func (s *TokenStore) GetByAccess(ctx context.Context, access string) (oauth2.TokenInfo, error) {
-	query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
-	bindVars := map[string]interface{}{
-		"@collection":  s.collection,
-		"access_token": access,
+func (s *TokenStore) GetByAccess(ctx context.Context, access string) (_ oauth2.TokenInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "GetByAccess", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("GetByAccess", s.collection, &err)
+
+	return s.getByField(ctx, "access_token", access)
+}
+
+// GetByRefresh returns the token by its refresh token. If the store is
+// configured to rotate refresh tokens, presenting a refresh token that was
+// already rotated revokes every token of its family and ErrRefreshTokenReuse
+// is returned.
+func (s *TokenStore) GetByRefresh(ctx context.Context, refresh string) (_ oauth2.TokenInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "GetByRefresh", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("GetByRefresh", s.collection, &err)
+
+	if s.tombstoneWindow == 0 {
+		return s.getByField(ctx, "refresh_token", refresh)
 	}
 
-	return s.getByQuery(ctx, query, bindVars)
+	return s.getRotatedByRefresh(ctx, refresh, true)
 }
 
-// GetByRefresh returns the token by its refresh token.
-func (s *TokenStore) GetByRefresh(ctx context.Context, refresh string) (oauth2.TokenInfo, error) {
-	query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token RETURN doc"
-	bindVars := map[string]interface{}{
-		"@collection":   s.collection,
-		"refresh_token": refresh,
+// FindByRefresh returns the token by its refresh token like GetByRefresh, but
+// presenting a refresh token that was already rotated does not revoke its
+// family. It is meant to look up the tokens presented by other parties than
+// their client, like the introspection and revocation endpoints.
+func (s *TokenStore) FindByRefresh(ctx context.Context, refresh string) (_ oauth2.TokenInfo, err error) {
+	ctx, op := s.telemetry.start(ctx, "FindByRefresh", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("FindByRefresh", s.collection, &err)
+
+	if s.tombstoneWindow == 0 {
+		return s.getByField(ctx, "refresh_token", refresh)
 	}
 
-	return s.getByQuery(ctx, query, bindVars)
+	return s.getRotatedByRefresh(ctx, refresh, false)
 }
 
-// RemoveByCode deletes the token by its authorization code.
-func (s *TokenStore) RemoveByCode(ctx context.Context, code string) error {
-	query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection"
-	bindVars := map[string]interface{}{
+// removeByField removes the tokens having the given value in the given field.
+func (s *TokenStore) removeByField(ctx context.Context, field string, value string) error {
+	// An empty value would match every document not holding such a token.
+	if value == "" {
+		return nil
+	}
+
+	query := "FOR doc IN @@collection FILTER doc." + field + " == @" + field + " REMOVE doc IN @@collection"
+	bindVars := map[string]any{
 		"@collection": s.collection,
-		"code":        code,
+		field:         value,
 	}
 
 	return s.removeByQuery(ctx, query, bindVars)
 }
 
-func (s *TokenStore) RemoveByAccess(ctx context.Context, access string) error {
-	query := "FOR doc IN @@collection FILTER doc.access_token == @access_token REMOVE doc IN @@collection"
-	bindVars := map[string]interface{}{
-		"@collection":  s.collection,
-		"access_token": access,
+// RemoveByCode deletes the token by its authorization code.
+func (s *TokenStore) RemoveByCode(ctx context.Context, code string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RemoveByCode", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RemoveByCode", s.collection, &err)
+
+	return s.removeByField(ctx, "code", s.hashToken(code))
+}
+
+// RemoveByAccess deletes the token by its access token.
+func (s *TokenStore) RemoveByAccess(ctx context.Context, access string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RemoveByAccess", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RemoveByAccess", s.collection, &err)
+
+	return s.removeByField(ctx, "access_token", s.hashToken(access))
+}
+
+// RemoveByRefresh deletes the token by its refresh token.
+func (s *TokenStore) RemoveByRefresh(ctx context.Context, refresh string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RemoveByRefresh", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RemoveByRefresh", s.collection, &err)
+
+	return s.removeByField(ctx, "refresh_token", s.hashToken(refresh))
+}
+
+// listByField returns a page of the tokens having the given value in the given
+// field, ordered by their document key. The returned cursor is empty if there
+// are no more pages.
+func (s *TokenStore) listByField(ctx context.Context, field string, value string, cursor string, limit int) (tokens []oauth2.TokenInfo, next string, err error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
 	}
 
-	return s.removeByQuery(ctx, query, bindVars)
+	query := "FOR doc IN @@collection FILTER doc." + field + " == @" + field +
+		" FILTER doc._key > @cursor SORT doc._key LIMIT @limit RETURN doc"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		field:         value,
+		"cursor":      cursor,
+		"limit":       limit,
+	}
+
+	err = s.retry.do(ctx, func() error {
+		var err error
+		tokens, next, err = s.queryPage(ctx, query, bindVars, limit)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tokens, next, nil
 }
 
-func (s *TokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
-	query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token REMOVE doc IN @@collection"
-	bindVars := map[string]interface{}{
-		"@collection":   s.collection,
-		"refresh_token": refresh,
+// queryPage returns the tokens returned by the query, which returns at most
+// limit documents ordered by their key, and the cursor of the next page.
+func (s *TokenStore) queryPage(ctx context.Context, query string, bindVars map[string]any, limit int) (tokens []oauth2.TokenInfo, next string, err error) {
+	c, err := s.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return nil, "", err
 	}
+	defer closeCursor(c, &err)
 
-	return s.removeByQuery(ctx, query, bindVars)
+	tokens = make([]oauth2.TokenInfo, 0, limit)
+	for c.HasMore() {
+		var doc TokenStoreItem
+		if _, err := c.ReadDocument(ctx, &doc); err != nil {
+			return nil, "", err
+		}
+
+		info, err := s.decodeToken(&doc)
+		if err != nil {
+			return nil, "", err
+		}
+
+		tokens = append(tokens, info)
+		next = doc.Key
+	}
+
+	if len(tokens) < limit {
+		next = ""
+	}
+
+	return tokens, next, nil
+}
+
+// countByField returns the number of tokens having the given value in the
+// given field.
+func (s *TokenStore) countByField(ctx context.Context, field string, value string) (count int, err error) {
+	query := "FOR doc IN @@collection FILTER doc." + field + " == @" + field + " COLLECT WITH COUNT INTO count RETURN count"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+		field:         value,
+	}
+
+	err = s.retry.do(ctx, func() (err error) {
+		c, err := s.db.Query(ctx, query, bindVars)
+		if err != nil {
+			return err
+		}
+		defer closeCursor(c, &err)
+
+		count = 0
+		if c.HasMore() {
+			if _, err := c.ReadDocument(ctx, &count); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// ListByUser returns a page of at most limit tokens issued to the user,
+// starting after the given cursor. Pass an empty cursor to get the first page
+// and the returned cursor to get the next one; the returned cursor is empty if
+// there are no more pages. Expired tokens are listed until they are removed.
+func (s *TokenStore) ListByUser(ctx context.Context, userID string, cursor string, limit int) (_ []oauth2.TokenInfo, _ string, err error) {
+	ctx, op := s.telemetry.start(ctx, "ListByUser", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("ListByUser", s.collection, &err)
+
+	return s.listByField(ctx, "user_id", userID, cursor, limit)
+}
+
+// RemoveByUser deletes every token issued to the user.
+func (s *TokenStore) RemoveByUser(ctx context.Context, userID string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RemoveByUser", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RemoveByUser", s.collection, &err)
+
+	return s.removeByField(ctx, "user_id", userID)
+}
+
+// CountByUser returns the number of tokens issued to the user.
+func (s *TokenStore) CountByUser(ctx context.Context, userID string) (_ int, err error) {
+	ctx, op := s.telemetry.start(ctx, "CountByUser", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("CountByUser", s.collection, &err)
+
+	return s.countByField(ctx, "user_id", userID)
+}
+
+// ListByClient returns a page of at most limit tokens issued to the client,
+// starting after the given cursor. Pass an empty cursor to get the first page
+// and the returned cursor to get the next one; the returned cursor is empty if
+// there are no more pages. Expired tokens are listed until they are removed.
+func (s *TokenStore) ListByClient(ctx context.Context, clientID string, cursor string, limit int) (_ []oauth2.TokenInfo, _ string, err error) {
+	ctx, op := s.telemetry.start(ctx, "ListByClient", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("ListByClient", s.collection, &err)
+
+	return s.listByField(ctx, "client_id", clientID, cursor, limit)
+}
+
+// RemoveByClient deletes every authorization code, access and refresh token
+// issued to the client.
+func (s *TokenStore) RemoveByClient(ctx context.Context, clientID string) (err error) {
+	ctx, op := s.telemetry.start(ctx, "RemoveByClient", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("RemoveByClient", s.collection, &err)
+
+	return s.removeByField(ctx, "client_id", clientID)
+}
+
+// MigrateExpiries sets the expiry of each token separately on documents that
+// were created before the separate expiry fields were introduced. It returns
+// the number of migrated documents.
+func (s *TokenStore) MigrateExpiries(ctx context.Context) (migrated int, err error) {
+	ctx, op := s.telemetry.start(ctx, "MigrateExpiries", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("MigrateExpiries", s.collection, &err)
+
+	coll, err := s.db.Collection(ctx, s.collection)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "FOR doc IN @@collection FILTER !HAS(doc, 'access_expires_at') RETURN doc"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+	}
+
+	cursor, err := s.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer closeCursor(cursor, &err)
+
+	for cursor.HasMore() {
+		var doc TokenStoreItem
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return migrated, err
+		}
+
+		info, err := s.decodeToken(&doc)
+		if err != nil {
+			return migrated, err
+		}
+
+		var expiries TokenStoreItem
+		setExpiries(&expiries, info)
+
+		patch := map[string]any{
+			"expires_at":         nullTime(expiries.ExpiresAt),
+			"code_expires_at":    nullTime(expiries.CodeExpiresAt),
+			"access_expires_at":  nullTime(expiries.AccessExpiresAt),
+			"refresh_expires_at": nullTime(expiries.RefreshExpiresAt),
+		}
+
+		if _, err := coll.UpdateDocument(ctx, doc.Key, patch); err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MigrateOwners sets the user and client ID on documents that were created
+// before these were stored separately, so they are found by the lookups by
+// user and client. It returns the number of migrated documents.
+func (s *TokenStore) MigrateOwners(ctx context.Context) (migrated int, err error) {
+	ctx, op := s.telemetry.start(ctx, "MigrateOwners", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("MigrateOwners", s.collection, &err)
+
+	coll, err := s.db.Collection(ctx, s.collection)
+	if err != nil {
+		return 0, err
+	}
+
+	query := "FOR doc IN @@collection FILTER !HAS(doc, 'user_id') RETURN doc"
+	bindVars := map[string]any{
+		"@collection": s.collection,
+	}
+
+	cursor, err := s.db.Query(ctx, query, bindVars)
+	if err != nil {
+		return 0, err
+	}
+	defer closeCursor(cursor, &err)
+
+	for cursor.HasMore() {
+		var doc TokenStoreItem
+		if _, err := cursor.ReadDocument(ctx, &doc); err != nil {
+			return migrated, err
+		}
+
+		info, err := s.decodeToken(&doc)
+		if err != nil {
+			return migrated, err
+		}
+
+		patch := map[string]any{
+			"user_id":   info.GetUserID(),
+			"client_id": info.GetClientID(),
+		}
+
+		if _, err := coll.UpdateDocument(ctx, doc.Key, patch); err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// Reencrypt encrypts the data of every token that is not encrypted using the
+// current key of the encryptor, including tokens stored before encryption was
+// enabled. It returns the number of re-encrypted tokens.
+func (s *TokenStore) Reencrypt(ctx context.Context) (_ int, err error) {
+	ctx, op := s.telemetry.start(ctx, "Reencrypt", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	defer wrapError("Reencrypt", s.collection, &err)
+
+	if s.encryptor == nil {
+		return 0, ErrNoEncryptor
+	}
+
+	return reencrypt(ctx, s.db, s.collection, s.encryptor, nil)
 }
 
 // NewTokenStore creates a new TokenStore.
@@ -217,5 +1271,28 @@ func NewTokenStore(opts ...TokenStoreOption) (*TokenStore, error) {
 		return nil, ErrNoDatabase
 	}
 
+	if s.ensureSchema {
+		report, err := s.EnsureSchema(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		if s.schemaReport != nil {
+			*s.schemaReport = *report
+		}
+	} else if s.ttl {
+		ctx, cancel := withTimeout(context.Background(), s.timeout)
+		defer cancel()
+
+		coll, err := s.db.Collection(ctx, s.collection)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.ensureTTLIndex(ctx, coll, new(SchemaReport)); err != nil {
+			return nil, err
+		}
+	}
+
 	return s, nil
 }
@@ -3,15 +3,20 @@ package arangostore
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/arangodb/go-driver"
 	"github.com/go-oauth2/oauth2/v4"
+	"github.com/go-oauth2/oauth2/v4/manage"
 	"github.com/go-oauth2/oauth2/v4/models"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/gabor-boros/go-oauth2-arangodb/arangotest"
 )
 
 func TestNewTokenStore(t *testing.T) {
@@ -78,6 +83,88 @@ func TestNewTokenStore(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "new client store with invalid clock",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreClock(nil),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new client store with invalid ttl grace period",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreTTL(-time.Second),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new token store with invalid tombstone window",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreRefreshRotation(0),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new token store with timeout",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreTimeout(time.Second),
+				},
+			},
+			want: &TokenStore{
+				db:         new(MockArangoDB),
+				collection: DefaultTokenStoreCollection,
+				timeout:    time.Second,
+			},
+		},
+		{
+			name: "new token store with invalid timeout",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreTimeout(0),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "new token store with retry",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreRetry(DefaultRetryPolicy()),
+				},
+			},
+			want: &TokenStore{
+				db:         new(MockArangoDB),
+				collection: DefaultTokenStoreCollection,
+				retry: &RetryPolicy{
+					MaxAttempts:    3,
+					InitialBackoff: 50 * time.Millisecond,
+					MaxBackoff:     time.Second,
+				},
+			},
+		},
+		{
+			name: "new token store with invalid retry",
+			args: args{
+				opts: []TokenStoreOption{
+					WithTokenStoreDatabase(new(MockArangoDB)),
+					WithTokenStoreRetry(RetryPolicy{}),
+				},
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -96,9 +183,15 @@ func TestNewTokenStore(t *testing.T) {
 }
 
 func TestTokenStore_Create(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
 	type fields struct {
-		db         func(ctx context.Context, info oauth2.TokenInfo) driver.Database
-		collection string
+		db              func(ctx context.Context, info oauth2.TokenInfo) driver.Database
+		collection      string
+		hashKey         []byte
+		dataAsObject    bool
+		clock           func() time.Time
+		revokeCodeReuse bool
 	}
 	type args struct {
 		ctx  context.Context
@@ -185,6 +278,193 @@ func TestTokenStore_Create(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "create token with creation time in UTC",
+			fields: fields{
+				db: func(ctx context.Context, info oauth2.TokenInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("CreateDocument", context.Background(), mock.MatchedBy(func(doc TokenStoreItem) bool {
+						return doc.CreatedAt == now
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+				clock: func() time.Time {
+					return now.In(time.FixedZone("CEST", 2*60*60)).Add(time.Microsecond)
+				},
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Token{
+					ClientID:        "client-id",
+					UserID:          "user-id",
+					Access:          "test-access-token",
+					AccessCreateAt:  now,
+					AccessExpiresIn: 10 * time.Second,
+				},
+			},
+		},
+		{
+			name: "create token issued from consumed code",
+			fields: fields{
+				db: func(ctx context.Context, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.client_id == @client_id FILTER doc.user_id == @user_id" +
+						" FILTER doc.consumed_at != null FILTER doc.issued != true FILTER DATE_TIMESTAMP(doc.consumed_at) >= @since" +
+						" SORT DATE_TIMESTAMP(doc.consumed_at) DESC LIMIT 1 UPDATE doc WITH { issued: true } IN @@collection RETURN NEW"
+					bindVars := map[string]any{
+						"@collection": DefaultTokenStoreCollection,
+						"client_id":   "client-id",
+						"user_id":     "user-id",
+						"since":       now.Add(-codeClaimWindow).UnixMilli(),
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", context.Background(), mock.Anything).Return(&TokenStoreItem{Key: "code-key"}, driver.DocumentMeta{}, nil)
+
+					coll := new(MockArangoCollection)
+					coll.On("CreateDocument", context.Background(), mock.MatchedBy(func(doc TokenStoreItem) bool {
+						return doc.CodeKey == "code-key"
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultTokenStoreCollection).Return(coll, nil)
+					db.On("Query", context.Background(), query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection:      DefaultTokenStoreCollection,
+				clock:           func() time.Time { return now },
+				revokeCodeReuse: true,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Token{
+					ClientID:        "client-id",
+					UserID:          "user-id",
+					Access:          "test-access-token",
+					AccessCreateAt:  now,
+					AccessExpiresIn: 10 * time.Second,
+				},
+			},
+		},
+		{
+			name: "create token with never expiring refresh token",
+			fields: fields{
+				db: func(ctx context.Context, info oauth2.TokenInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("CreateDocument", context.Background(), mock.MatchedBy(func(doc TokenStoreItem) bool {
+						return doc.ExpiresAt.IsZero()
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Token{
+					ClientID:        "client-id",
+					UserID:          "user-id",
+					Access:          "test-access-token",
+					AccessCreateAt:  time.Now(),
+					AccessExpiresIn: 10 * time.Second,
+					Refresh:         "test-refresh-token",
+					RefreshCreateAt: time.Now(),
+				},
+			},
+		},
+		{
+			name: "create token with hashed tokens",
+			fields: fields{
+				db: func(ctx context.Context, info oauth2.TokenInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("CreateDocument", context.Background(), mock.MatchedBy(func(doc TokenStoreItem) bool {
+						raw, err := decodeData(doc.Data)
+						if err != nil {
+							return false
+						}
+
+						var data models.Token
+						if err := json.Unmarshal(raw, &data); err != nil {
+							return false
+						}
+
+						hasher := &TokenStore{hashKey: []byte("secret-key")}
+
+						return doc.Access == hasher.hashToken(info.GetAccess()) &&
+							doc.Refresh == hasher.hashToken(info.GetRefresh()) &&
+							data.Access == "" &&
+							data.Refresh == "" &&
+							data.UserID == info.GetUserID()
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+				hashKey:    []byte("secret-key"),
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Token{
+					ClientID:         "client-id",
+					UserID:           "user-id",
+					Access:           "test-access-token",
+					AccessCreateAt:   time.Now(),
+					AccessExpiresIn:  10 * time.Second,
+					Refresh:          "test-refresh-token",
+					RefreshCreateAt:  time.Now(),
+					RefreshExpiresIn: 10 * time.Second,
+				},
+			},
+		},
+		{
+			name: "create token with data as object",
+			fields: fields{
+				db: func(ctx context.Context, info oauth2.TokenInfo) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("CreateDocument", context.Background(), mock.MatchedBy(func(doc TokenStoreItem) bool {
+						var data map[string]any
+						if err := json.Unmarshal(doc.Data, &data); err != nil {
+							return false
+						}
+
+						return data["UserID"] == info.GetUserID() &&
+							doc.UserID == info.GetUserID() &&
+							doc.ClientID == info.GetClientID()
+					})).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", context.Background(), DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection:   DefaultTokenStoreCollection,
+				dataAsObject: true,
+			},
+			args: args{
+				ctx: context.Background(),
+				info: &models.Token{
+					ClientID:        "client-id",
+					UserID:          "user-id",
+					Access:          "test-access-token",
+					AccessCreateAt:  time.Now(),
+					AccessExpiresIn: 10 * time.Second,
+				},
+			},
+		},
 		{
 			name: "create token with collection error",
 			fields: fields{
@@ -240,8 +520,12 @@ func TestTokenStore_Create(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(tt.args.ctx, tt.args.info),
-				collection: tt.fields.collection,
+				db:              tt.fields.db(tt.args.ctx, tt.args.info),
+				collection:      tt.fields.collection,
+				hashKey:         tt.fields.hashKey,
+				dataAsObject:    tt.fields.dataAsObject,
+				clock:           tt.fields.clock,
+				revokeCodeReuse: tt.fields.revokeCodeReuse,
 			}
 			if err := s.Create(tt.args.ctx, tt.args.info); (err != nil) != tt.wantErr {
 				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
@@ -252,19 +536,21 @@ func TestTokenStore_Create(t *testing.T) {
 
 func TestTokenStore_GetByAccess(t *testing.T) {
 	type fields struct {
-		db         func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database
-		collection string
+		db            func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database
+		collection    string
+		notFoundAsNil bool
 	}
 	type args struct {
 		ctx    context.Context
 		access string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    oauth2.TokenInfo
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		want      oauth2.TokenInfo
+		wantErr   bool
+		wantErrIs error
 	}{
 		{
 			name: "get token by access token",
@@ -338,7 +624,7 @@ func TestTokenStore_GetByAccess(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name: "get token by access token with read document error",
+			name: "get token by access token not found",
 			fields: fields{
 				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
 					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
@@ -349,9 +635,7 @@ func TestTokenStore_GetByAccess(t *testing.T) {
 
 					cursor := new(MockArangoCursor)
 					cursor.On("Close").Return(nil)
-					cursor.On("HasMore").Return(true, nil).Once()
 					cursor.On("HasMore").Return(false, nil).Once()
-					cursor.On("ReadDocument", ctx, mock.Anything).Return(nil, driver.DocumentMeta{}, fmt.Errorf("error"))
 
 					db := new(MockArangoDB)
 					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
@@ -364,80 +648,263 @@ func TestTokenStore_GetByAccess(t *testing.T) {
 				ctx:    context.Background(),
 				access: "test-access-token",
 			},
-			wantErr: true,
+			wantErr:   true,
+			wantErrIs: ErrTokenNotFound,
 		},
-	}
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			s := &TokenStore{
-				db:         tt.fields.db(tt.args.ctx, tt.args.access, tt.want),
-				collection: tt.fields.collection,
-			}
-			got, err := s.GetByAccess(tt.args.ctx, tt.args.access)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("GetByAccess() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GetByAccess() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestTokenStore_GetByCode(t *testing.T) {
-	type fields struct {
-		db         func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database
-		collection string
-	}
-	type args struct {
-		ctx  context.Context
-		code string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    oauth2.TokenInfo
-		wantErr bool
-	}{
 		{
-			name: "get token by code",
+			name: "get token by access token not found as nil",
 			fields: fields{
-				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.code == @code RETURN doc"
+				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
 					bindVars := map[string]interface{}{
-						"@collection": DefaultTokenStoreCollection,
-						"code":        code,
-					}
-
-					data, err := json.Marshal(info)
-					if err != nil {
-						t.Fatal(err)
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
 					}
 
-					var doc TokenStoreItem
-
 					cursor := new(MockArangoCursor)
 					cursor.On("Close").Return(nil)
-					cursor.On("HasMore").Return(true, nil).Once()
 					cursor.On("HasMore").Return(false, nil).Once()
-					cursor.On("ReadDocument", ctx, &doc).Return(&TokenStoreItem{
-						Key:     "test-key",
-						Code:    info.GetCode(),
-						Access:  info.GetAccess(),
-						Refresh: info.GetRefresh(),
-						Data:    data,
-					}, driver.DocumentMeta{}, nil)
 
 					db := new(MockArangoDB)
 					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
 
 					return db
 				},
-				collection: DefaultTokenStoreCollection,
+				collection:    DefaultTokenStoreCollection,
+				notFoundAsNil: true,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "test-access-token",
+			},
+		},
+		{
+			name: "get token by empty access token",
+			fields: fields{
+				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
+					return new(MockArangoDB)
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "",
+			},
+			wantErr:   true,
+			wantErrIs: ErrTokenNotFound,
+		},
+		{
+			name: "get token by access token with read document error",
+			fields: fields{
+				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("HasMore").Return(false, nil).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(nil, driver.DocumentMeta{}, fmt.Errorf("error"))
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "test-access-token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "get token by access token with close cursor error",
+			fields: fields{
+				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(errCloseCursor)
+					cursor.On("HasMore").Return(false, nil).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "test-access-token",
+			},
+			wantErr:   true,
+			wantErrIs: errCloseCursor,
+		},
+		{
+			name: "get token by access token with read document and close cursor error",
+			fields: fields{
+				db: func(ctx context.Context, access string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(errCloseCursor)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(nil, driver.DocumentMeta{}, context.DeadlineExceeded)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "test-access-token",
+			},
+			wantErr:   true,
+			wantErrIs: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:            tt.fields.db(tt.args.ctx, tt.args.access, tt.want),
+				collection:    tt.fields.collection,
+				notFoundAsNil: tt.fields.notFoundAsNil,
+			}
+			got, err := s.GetByAccess(tt.args.ctx, tt.args.access)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetByAccess() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("GetByAccess() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetByAccess() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_GetByCode(t *testing.T) {
+	type fields struct {
+		db            func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database
+		collection    string
+		rejectExpired bool
+		clock         func() time.Time
+	}
+	type args struct {
+		ctx  context.Context
+		code string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    oauth2.TokenInfo
+		wantErr bool
+	}{
+		{
+			name: "get token by code",
+			fields: fields{
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+					}
+
+					data, err := json.Marshal(info)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					var doc TokenStoreItem
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("HasMore").Return(false, nil).Once()
+					cursor.On("ReadDocument", ctx, &doc).Return(&TokenStoreItem{
+						Key:     "test-key",
+						Code:    info.GetCode(),
+						Access:  info.GetAccess(),
+						Refresh: info.GetRefresh(),
+						Data:    data,
+					}, driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: "test-code",
+			},
+			want: &models.Token{
+				Code:          "test-code",
+				CodeCreateAt:  time.Time{},
+				CodeExpiresIn: 10 * time.Second,
+			},
+		},
+		{
+			name: "get token by code stored as base64 string",
+			fields: fields{
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+					}
+
+					data, err := json.Marshal(info)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					data, err = encodeData(data, false)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("HasMore").Return(false, nil).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:  "test-key",
+						Code: info.GetCode(),
+						Data: data,
+					}, driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
 				ctx:  context.Background(),
@@ -449,6 +916,38 @@ func TestTokenStore_GetByCode(t *testing.T) {
 				CodeExpiresIn: 10 * time.Second,
 			},
 		},
+		{
+			name: "get token by code rejecting expired tokens",
+			fields: fields{
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code LET expires_at = HAS(doc, 'code_expires_at') ? doc.code_expires_at : doc.expires_at FILTER expires_at == null OR DATE_TIMESTAMP(expires_at) > @now RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+						"now":         int64(1685959200000),
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(false, nil).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection:    DefaultTokenStoreCollection,
+				rejectExpired: true,
+				clock: func() time.Time {
+					return time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+				},
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: "test-code",
+			},
+			wantErr: true,
+		},
 		{
 			name: "get token by code with query error",
 			fields: fields{
@@ -507,8 +1006,10 @@ func TestTokenStore_GetByCode(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(tt.args.ctx, tt.args.code, tt.want),
-				collection: tt.fields.collection,
+				db:            tt.fields.db(tt.args.ctx, tt.args.code, tt.want),
+				collection:    tt.fields.collection,
+				rejectExpired: tt.fields.rejectExpired,
+				clock:         tt.fields.clock,
 			}
 			got, err := s.GetByCode(tt.args.ctx, tt.args.code)
 			if (err != nil) != tt.wantErr {
@@ -526,6 +1027,7 @@ func TestTokenStore_GetByRefresh(t *testing.T) {
 	type fields struct {
 		db         func(ctx context.Context, refresh string, want oauth2.TokenInfo) driver.Database
 		collection string
+		hashKey    []byte
 	}
 	type args struct {
 		ctx     context.Context
@@ -586,54 +1088,927 @@ func TestTokenStore_GetByRefresh(t *testing.T) {
 			},
 		},
 		{
-			name: "get token by refresh token with query error",
+			name: "get token by hashed refresh token",
 			fields: fields{
 				db: func(ctx context.Context, refresh string, info oauth2.TokenInfo) driver.Database {
+					hashed := (&TokenStore{hashKey: []byte("secret-key")}).hashToken(refresh)
+
 					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token RETURN doc"
 					bindVars := map[string]interface{}{
 						"@collection":   DefaultTokenStoreCollection,
-						"refresh_token": refresh,
+						"refresh_token": hashed,
+					}
+
+					stripped := copyToken(info)
+					stripped.Refresh = ""
+
+					data, err := json.Marshal(stripped)
+					if err != nil {
+						t.Fatal(err)
 					}
 
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("HasMore").Return(false, nil).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:     "test-key",
+						Refresh: hashed,
+						Data:    data,
+					}, driver.DocumentMeta{}, nil)
+
 					db := new(MockArangoDB)
-					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
 
 					return db
 				},
 				collection: DefaultTokenStoreCollection,
+				hashKey:    []byte("secret-key"),
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			want: &HashedToken{
+				Token: &models.Token{
+					ClientID:         "client-id",
+					UserID:           "user-id",
+					Refresh:          "test-refresh-token",
+					RefreshExpiresIn: 10 * time.Second,
+				},
+				key:     "test-key",
+				refresh: "test-refresh-token",
+			},
+		},
+		{
+			name: "get token by refresh token with query error",
+			fields: fields{
+				db: func(ctx context.Context, refresh string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection":   DefaultTokenStoreCollection,
+						"refresh_token": refresh,
+					}
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "get token by refresh token with read document error",
+			fields: fields{
+				db: func(ctx context.Context, refresh string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection":   DefaultTokenStoreCollection,
+						"refresh_token": refresh,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true, nil).Once()
+					cursor.On("HasMore").Return(false, nil).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(nil, driver.DocumentMeta{}, fmt.Errorf("error"))
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(context.Background(), tt.args.refresh, tt.want),
+				collection: tt.fields.collection,
+				hashKey:    tt.fields.hashKey,
+			}
+			got, err := s.GetByRefresh(tt.args.ctx, tt.args.refresh)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetByRefresh() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetByRefresh() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_RemoveByAccess(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, access string) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx    context.Context
+		access string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "remove token by access token",
+			fields: fields{
+				db: func(ctx context.Context, access string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: "test-access-token",
+			},
+		},
+		{
+			name: "remove token by access token with query error",
+			fields: fields{
+				db: func(ctx context.Context, access string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection":  DefaultTokenStoreCollection,
+						"access_token": access,
+					}
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				access: DefaultTokenStoreCollection,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(context.Background(), tt.args.access),
+				collection: tt.fields.collection,
+			}
+			if err := s.RemoveByAccess(tt.args.ctx, tt.args.access); (err != nil) != tt.wantErr {
+				t.Errorf("RemoveByAccess() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenStore_RemoveByCode(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, code string) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx  context.Context
+		code string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "remove token by code",
+			fields: fields{
+				db: func(ctx context.Context, code string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: "test-code",
+			},
+		},
+		{
+			name: "remove token by code with query error",
+			fields: fields{
+				db: func(ctx context.Context, code string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+					}
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: DefaultTokenStoreCollection,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(context.Background(), tt.args.code),
+				collection: tt.fields.collection,
+			}
+			if err := s.RemoveByCode(tt.args.ctx, tt.args.code); (err != nil) != tt.wantErr {
+				t.Errorf("RemoveByCode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenStore_RemoveByRefresh(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, refresh string) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx     context.Context
+		refresh string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "remove token by refresh token",
+			fields: fields{
+				db: func(ctx context.Context, refresh string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection":   DefaultTokenStoreCollection,
+						"refresh_token": refresh,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: "test-refresh-token",
+			},
+		},
+		{
+			name: "remove token by refresh token with query error",
+			fields: fields{
+				db: func(ctx context.Context, refresh string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection":   DefaultTokenStoreCollection,
+						"refresh_token": refresh,
+					}
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:     context.Background(),
+				refresh: DefaultTokenStoreCollection,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(context.Background(), tt.args.refresh),
+				collection: tt.fields.collection,
+			}
+			if err := s.RemoveByRefresh(tt.args.ctx, tt.args.refresh); (err != nil) != tt.wantErr {
+				t.Errorf("RemoveByRefresh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenStore_EnsureSchema(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context) driver.Database
+		collection string
+		ttl        bool
+		ttlGrace   time.Duration
+	}
+	type args struct {
+		ctx context.Context
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *SchemaReport
+		wantErr bool
+	}{
+		{
+			name: "ensure schema with existing collection and indexes",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					coll := new(MockArangoCollection)
+					for _, field := range tokenStoreIndexes {
+						coll.On("EnsurePersistentIndex", ctx, []string{field}, &driver.EnsurePersistentIndexOptions{Name: "idx_" + field}).Return(nil, false, nil)
+					}
+
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultTokenStoreCollection).Return(true, nil)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: &SchemaReport{},
+		},
+		{
+			name: "ensure schema with missing collection",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					coll := new(MockArangoCollection)
+					for _, field := range tokenStoreIndexes {
+						coll.On("EnsurePersistentIndex", ctx, []string{field}, &driver.EnsurePersistentIndexOptions{Name: "idx_" + field}).Return(nil, true, nil)
+					}
+
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultTokenStoreCollection).Return(false, nil)
+					db.On("CreateCollection", ctx, DefaultTokenStoreCollection, (*driver.CreateCollectionOptions)(nil)).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: &SchemaReport{
+				CollectionCreated: true,
+				CreatedIndexes:    []string{"idx_code", "idx_access_token", "idx_refresh_token", "idx_user_id", "idx_client_id", "idx_family_id", "idx_rotated_refresh_token"},
+			},
+		},
+		{
+			name: "ensure schema with ttl index",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					coll := new(MockArangoCollection)
+					for _, field := range tokenStoreIndexes {
+						coll.On("EnsurePersistentIndex", ctx, []string{field}, mock.Anything).Return(nil, false, nil)
+					}
+					coll.On("EnsureTTLIndex", ctx, "expires_at", 60, &driver.EnsureTTLIndexOptions{Name: "ttl_expires_at"}).Return(nil, true, nil)
+
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultTokenStoreCollection).Return(true, nil)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+				ttl:        true,
+				ttlGrace:   time.Minute,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: &SchemaReport{
+				CreatedIndexes: []string{"ttl_expires_at"},
+			},
+		},
+		{
+			name: "ensure schema with collection exists error",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultTokenStoreCollection).Return(false, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "ensure schema with index error",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					coll := new(MockArangoCollection)
+					coll.On("EnsurePersistentIndex", ctx, []string{"code"}, mock.Anything).Return(nil, false, fmt.Errorf("error"))
+
+					db := new(MockArangoDB)
+					db.On("CollectionExists", ctx, DefaultTokenStoreCollection).Return(true, nil)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(tt.args.ctx),
+				collection: tt.fields.collection,
+				ttl:        tt.fields.ttl,
+				ttlGrace:   tt.fields.ttlGrace,
+			}
+			got, err := s.EnsureSchema(tt.args.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EnsureSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EnsureSchema() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStoreItem_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		item TokenStoreItem
+		want any
+	}{
+		{
+			name: "marshal item with expiry",
+			item: TokenStoreItem{
+				ExpiresAt: time.Date(2023, 6, 5, 10, 0, 0, 123000000, time.UTC),
+			},
+			want: "2023-06-05T10:00:00.123Z",
+		},
+		{
+			name: "marshal item without expiry",
+			item: TokenStoreItem{},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			data, err := json.Marshal(tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]any
+			if err = json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got["expires_at"], tt.want) {
+				t.Errorf("MarshalJSON() expires_at = %v, want %v", got["expires_at"], tt.want)
+			}
+		})
+	}
+}
+
+func TestSetExpiries(t *testing.T) {
+	createdAt := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		info oauth2.TokenInfo
+		want TokenStoreItem
+	}{
+		{
+			name: "set expiries of code",
+			info: &models.Token{
+				Code:          "test-code",
+				CodeCreateAt:  createdAt,
+				CodeExpiresIn: time.Minute,
+			},
+			want: TokenStoreItem{
+				ExpiresAt:     createdAt.Add(time.Minute),
+				CodeExpiresAt: createdAt.Add(time.Minute),
+			},
+		},
+		{
+			name: "set expiries of access and refresh tokens",
+			info: &models.Token{
+				Access:           "test-access-token",
+				AccessCreateAt:   createdAt,
+				AccessExpiresIn:  time.Minute,
+				Refresh:          "test-refresh-token",
+				RefreshCreateAt:  createdAt,
+				RefreshExpiresIn: time.Hour,
+			},
+			want: TokenStoreItem{
+				ExpiresAt:        createdAt.Add(time.Hour),
+				AccessExpiresAt:  createdAt.Add(time.Minute),
+				RefreshExpiresAt: createdAt.Add(time.Hour),
+			},
+		},
+		{
+			name: "set expiries of never expiring refresh token",
+			info: &models.Token{
+				Access:          "test-access-token",
+				AccessCreateAt:  createdAt,
+				AccessExpiresIn: time.Minute,
+				Refresh:         "test-refresh-token",
+				RefreshCreateAt: createdAt,
+			},
+			want: TokenStoreItem{
+				AccessExpiresAt: createdAt.Add(time.Minute),
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got TokenStoreItem
+			setExpiries(&got, tt.info)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setExpiries() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_MigrateExpiries(t *testing.T) {
+	createdAt := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	type fields struct {
+		db         func(ctx context.Context) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx context.Context
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "migrate expiries",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					query := "FOR doc IN @@collection FILTER !HAS(doc, 'access_expires_at') RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+					}
+
+					data, err := json.Marshal(&models.Token{
+						Access:           "test-access-token",
+						AccessCreateAt:   createdAt,
+						AccessExpiresIn:  time.Minute,
+						Refresh:          "test-refresh-token",
+						RefreshCreateAt:  createdAt,
+						RefreshExpiresIn: time.Hour,
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:     "test-key",
+						Access:  "test-access-token",
+						Refresh: "test-refresh-token",
+						Data:    data,
+					}, driver.DocumentMeta{}, nil)
+
+					accessExpiresAt := createdAt.Add(time.Minute)
+					refreshExpiresAt := createdAt.Add(time.Hour)
+
+					coll := new(MockArangoCollection)
+					coll.On("UpdateDocument", ctx, "test-key", map[string]any{
+						"expires_at":         &refreshExpiresAt,
+						"code_expires_at":    (*time.Time)(nil),
+						"access_expires_at":  &accessExpiresAt,
+						"refresh_expires_at": &refreshExpiresAt,
+					}).Return(driver.DocumentMeta{}, nil)
+
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			want: 1,
+		},
+		{
+			name: "migrate expiries with query error",
+			fields: fields{
+				db: func(ctx context.Context) driver.Database {
+					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(new(MockArangoCollection), nil)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx: context.Background(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(tt.args.ctx),
+				collection: tt.fields.collection,
+			}
+			got, err := s.MigrateExpiries(tt.args.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MigrateExpiries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("MigrateExpiries() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_ListByUser(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, userID string, cursor string, limit int, tokens []oauth2.TokenInfo) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+		cursor string
+		limit  int
+	}
+	tests := []struct {
+		name     string
+		fields   fields
+		args     args
+		want     []oauth2.TokenInfo
+		wantNext string
+		wantErr  bool
+	}{
+		{
+			name: "list tokens by user",
+			fields: fields{
+				db: func(ctx context.Context, userID string, cursor string, limit int, tokens []oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.user_id == @user_id FILTER doc._key > @cursor SORT doc._key LIMIT @limit RETURN doc"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"user_id":     userID,
+						"cursor":      cursor,
+						"limit":       limit,
+					}
+
+					c := new(MockArangoCursor)
+					c.On("Close").Return(nil)
+					for _, token := range tokens {
+						data, err := json.Marshal(token)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						c.On("HasMore").Return(true).Once()
+						c.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+							Key:    token.GetAccess(),
+							Access: token.GetAccess(),
+							UserID: token.GetUserID(),
+							Data:   data,
+						}, driver.DocumentMeta{}, nil).Once()
+					}
+					c.On("HasMore").Return(false).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(c, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "user-id",
+				limit:  2,
+			},
+			want: []oauth2.TokenInfo{
+				&models.Token{UserID: "user-id", Access: "access-1"},
+				&models.Token{UserID: "user-id", Access: "access-2"},
+			},
+			wantNext: "access-2",
+		},
+		{
+			name: "list tokens by user last page",
+			fields: fields{
+				db: func(ctx context.Context, userID string, cursor string, limit int, tokens []oauth2.TokenInfo) driver.Database {
+					c := new(MockArangoCursor)
+					c.On("Close").Return(nil)
+					c.On("HasMore").Return(false).Once()
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(c, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "user-id",
+				cursor: "access-2",
+				limit:  2,
+			},
+			want: []oauth2.TokenInfo{},
+		},
+		{
+			name: "list tokens by user with invalid limit",
+			fields: fields{
+				db: func(ctx context.Context, userID string, cursor string, limit int, tokens []oauth2.TokenInfo) driver.Database {
+					return new(MockArangoDB)
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "user-id",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			s := &TokenStore{
+				db:         tt.fields.db(tt.args.ctx, tt.args.userID, tt.args.cursor, tt.args.limit, tt.want),
+				collection: tt.fields.collection,
+			}
+			got, next, err := s.ListByUser(tt.args.ctx, tt.args.userID, tt.args.cursor, tt.args.limit)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListByUser() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListByUser() got = %v, want %v", got, tt.want)
+			}
+			if next != tt.wantNext {
+				t.Errorf("ListByUser() next = %v, want %v", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestTokenStore_RemoveByUser(t *testing.T) {
+	type fields struct {
+		db         func(ctx context.Context, userID string) driver.Database
+		collection string
+	}
+	type args struct {
+		ctx    context.Context
+		userID string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "remove tokens by user",
+			fields: fields{
+				db: func(ctx context.Context, userID string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.user_id == @user_id REMOVE doc IN @@collection"
+					bindVars := map[string]interface{}{
+						"@collection": DefaultTokenStoreCollection,
+						"user_id":     userID,
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+					return db
+				},
+				collection: DefaultTokenStoreCollection,
+			},
+			args: args{
+				ctx:    context.Background(),
+				userID: "user-id",
+			},
+		},
+		{
+			name: "remove tokens by empty user",
+			fields: fields{
+				db: func(ctx context.Context, userID string) driver.Database {
+					return new(MockArangoDB)
+				},
+				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
-				ctx:     context.Background(),
-				refresh: "test-refresh-token",
+				ctx: context.Background(),
 			},
-			wantErr: true,
 		},
 		{
-			name: "get token by refresh token with read document error",
+			name: "remove tokens by user with query error",
 			fields: fields{
-				db: func(ctx context.Context, refresh string, info oauth2.TokenInfo) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token RETURN doc"
-					bindVars := map[string]interface{}{
-						"@collection":   DefaultTokenStoreCollection,
-						"refresh_token": refresh,
-					}
-
-					cursor := new(MockArangoCursor)
-					cursor.On("Close").Return(nil)
-					cursor.On("HasMore").Return(true, nil).Once()
-					cursor.On("HasMore").Return(false, nil).Once()
-					cursor.On("ReadDocument", ctx, mock.Anything).Return(nil, driver.DocumentMeta{}, fmt.Errorf("error"))
-
+				db: func(ctx context.Context, userID string) driver.Database {
 					db := new(MockArangoDB)
-					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
 
 					return db
 				},
 				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
-				ctx:     context.Background(),
-				refresh: "test-refresh-token",
+				ctx:    context.Background(),
+				userID: "user-id",
 			},
 			wantErr: true,
 		},
@@ -643,48 +2018,46 @@ func TestTokenStore_GetByRefresh(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(context.Background(), tt.args.refresh, tt.want),
+				db:         tt.fields.db(tt.args.ctx, tt.args.userID),
 				collection: tt.fields.collection,
 			}
-			got, err := s.GetByRefresh(tt.args.ctx, tt.args.refresh)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("GetByRefresh() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("GetByRefresh() got = %v, want %v", got, tt.want)
+			if err := s.RemoveByUser(tt.args.ctx, tt.args.userID); (err != nil) != tt.wantErr {
+				t.Errorf("RemoveByUser() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestTokenStore_RemoveByAccess(t *testing.T) {
+func TestTokenStore_CountByUser(t *testing.T) {
 	type fields struct {
-		db         func(ctx context.Context, access string) driver.Database
+		db         func(ctx context.Context, userID string) driver.Database
 		collection string
 	}
 	type args struct {
 		ctx    context.Context
-		access string
+		userID string
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		args    args
+		want    int
 		wantErr bool
 	}{
 		{
-			name: "remove token by access token",
+			name: "count tokens by user",
 			fields: fields{
-				db: func(ctx context.Context, access string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token REMOVE doc IN @@collection"
+				db: func(ctx context.Context, userID string) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.user_id == @user_id COLLECT WITH COUNT INTO count RETURN count"
 					bindVars := map[string]interface{}{
-						"@collection":  DefaultTokenStoreCollection,
-						"access_token": access,
+						"@collection": DefaultTokenStoreCollection,
+						"user_id":     userID,
 					}
 
 					cursor := new(MockArangoCursor)
 					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(3, driver.DocumentMeta{}, nil)
 
 					db := new(MockArangoDB)
 					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
@@ -695,21 +2068,16 @@ func TestTokenStore_RemoveByAccess(t *testing.T) {
 			},
 			args: args{
 				ctx:    context.Background(),
-				access: "test-access-token",
+				userID: "user-id",
 			},
+			want: 3,
 		},
 		{
-			name: "remove token by access token with query error",
+			name: "count tokens by user with query error",
 			fields: fields{
-				db: func(ctx context.Context, access string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.access_token == @access_token REMOVE doc IN @@collection"
-					bindVars := map[string]interface{}{
-						"@collection":  DefaultTokenStoreCollection,
-						"access_token": access,
-					}
-
+				db: func(ctx context.Context, userID string) driver.Database {
 					db := new(MockArangoDB)
-					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(nil, fmt.Errorf("error"))
 
 					return db
 				},
@@ -717,7 +2085,7 @@ func TestTokenStore_RemoveByAccess(t *testing.T) {
 			},
 			args: args{
 				ctx:    context.Background(),
-				access: DefaultTokenStoreCollection,
+				userID: "user-id",
 			},
 			wantErr: true,
 		},
@@ -727,45 +2095,72 @@ func TestTokenStore_RemoveByAccess(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(context.Background(), tt.args.access),
+				db:         tt.fields.db(tt.args.ctx, tt.args.userID),
 				collection: tt.fields.collection,
 			}
-			if err := s.RemoveByAccess(tt.args.ctx, tt.args.access); (err != nil) != tt.wantErr {
-				t.Errorf("RemoveByAccess() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := s.CountByUser(tt.args.ctx, tt.args.userID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CountByUser() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CountByUser() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestTokenStore_RemoveByCode(t *testing.T) {
+func TestTokenStore_MigrateOwners(t *testing.T) {
 	type fields struct {
-		db         func(ctx context.Context, code string) driver.Database
+		db         func(ctx context.Context) driver.Database
 		collection string
 	}
 	type args struct {
-		ctx  context.Context
-		code string
+		ctx context.Context
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		args    args
+		want    int
 		wantErr bool
 	}{
 		{
-			name: "remove token by code",
+			name: "migrate owners",
 			fields: fields{
-				db: func(ctx context.Context, code string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection"
+				db: func(ctx context.Context) driver.Database {
+					query := "FOR doc IN @@collection FILTER !HAS(doc, 'user_id') RETURN doc"
 					bindVars := map[string]interface{}{
 						"@collection": DefaultTokenStoreCollection,
-						"code":        code,
+					}
+
+					data, err := json.Marshal(&models.Token{
+						ClientID: "client-id",
+						UserID:   "user-id",
+						Access:   "test-access-token",
+					})
+					if err != nil {
+						t.Fatal(err)
 					}
 
 					cursor := new(MockArangoCursor)
 					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:    "test-key",
+						Access: "test-access-token",
+						Data:   data,
+					}, driver.DocumentMeta{}, nil)
+
+					coll := new(MockArangoCollection)
+					coll.On("UpdateDocument", ctx, "test-key", map[string]any{
+						"user_id":   "user-id",
+						"client_id": "client-id",
+					}).Return(driver.DocumentMeta{}, nil)
 
 					db := new(MockArangoDB)
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(coll, nil)
 					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
 
 					return db
@@ -773,30 +2168,23 @@ func TestTokenStore_RemoveByCode(t *testing.T) {
 				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
-				ctx:  context.Background(),
-				code: "test-code",
+				ctx: context.Background(),
 			},
+			want: 1,
 		},
 		{
-			name: "remove token by code with query error",
+			name: "migrate owners with collection error",
 			fields: fields{
-				db: func(ctx context.Context, code string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection"
-					bindVars := map[string]interface{}{
-						"@collection": DefaultTokenStoreCollection,
-						"code":        code,
-					}
-
+				db: func(ctx context.Context) driver.Database {
 					db := new(MockArangoDB)
-					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+					db.On("Collection", ctx, DefaultTokenStoreCollection).Return(nil, fmt.Errorf("error"))
 
 					return db
 				},
 				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
-				ctx:  context.Background(),
-				code: DefaultTokenStoreCollection,
+				ctx: context.Background(),
 			},
 			wantErr: true,
 		},
@@ -806,78 +2194,233 @@ func TestTokenStore_RemoveByCode(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(context.Background(), tt.args.code),
+				db:         tt.fields.db(tt.args.ctx),
 				collection: tt.fields.collection,
 			}
-			if err := s.RemoveByCode(tt.args.ctx, tt.args.code); (err != nil) != tt.wantErr {
-				t.Errorf("RemoveByCode() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := s.MigrateOwners(tt.args.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MigrateOwners() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("MigrateOwners() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestTokenStore_RemoveByRefresh(t *testing.T) {
+func TestTokenStore_ListByClient(t *testing.T) {
+	ctx := context.Background()
+
+	query := "FOR doc IN @@collection FILTER doc.client_id == @client_id FILTER doc._key > @cursor SORT doc._key LIMIT @limit RETURN doc"
+	bindVars := map[string]interface{}{
+		"@collection": DefaultTokenStoreCollection,
+		"client_id":   "client-id",
+		"cursor":      "",
+		"limit":       10,
+	}
+
+	data, err := json.Marshal(&models.Token{ClientID: "client-id", Access: "test-access-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor := new(MockArangoCursor)
+	cursor.On("Close").Return(nil)
+	cursor.On("HasMore").Return(true).Once()
+	cursor.On("HasMore").Return(false).Once()
+	cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+		Key:      "test-key",
+		ClientID: "client-id",
+		Data:     data,
+	}, driver.DocumentMeta{}, nil)
+
+	db := new(MockArangoDB)
+	db.On("Query", ctx, query, bindVars).Return(cursor, nil)
+
+	s := &TokenStore{
+		db:         db,
+		collection: DefaultTokenStoreCollection,
+	}
+
+	got, next, err := s.ListByClient(ctx, "client-id", "", 10)
+	if err != nil {
+		t.Fatalf("ListByClient() error = %v", err)
+	}
+
+	want := []oauth2.TokenInfo{&models.Token{ClientID: "client-id", Access: "test-access-token"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListByClient() got = %v, want %v", got, want)
+	}
+	if next != "" {
+		t.Errorf("ListByClient() next = %v, want empty", next)
+	}
+}
+
+func TestTokenStore_ConsumeByCode(t *testing.T) {
+	now := time.Date(2023, 6, 5, 10, 0, 0, 0, time.UTC)
+	consumedAt := now.Add(-time.Minute)
+
+	// The consumption time is stored in UTC with millisecond precision.
+	clock := func() time.Time {
+		return now.In(time.FixedZone("CEST", 2*60*60)).Add(time.Microsecond)
+	}
+
 	type fields struct {
-		db         func(ctx context.Context, refresh string) driver.Database
-		collection string
+		db              func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database
+		revokeCodeReuse bool
 	}
 	type args struct {
-		ctx     context.Context
-		refresh string
+		ctx  context.Context
+		code string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name      string
+		fields    fields
+		args      args
+		want      oauth2.TokenInfo
+		wantErr   bool
+		wantErrIs error
 	}{
 		{
-			name: "remove token by refresh token",
+			name: "consume code",
 			fields: fields{
-				db: func(ctx context.Context, refresh string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token REMOVE doc IN @@collection"
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code REMOVE doc IN @@collection RETURN OLD"
 					bindVars := map[string]interface{}{
-						"@collection":   DefaultTokenStoreCollection,
-						"refresh_token": refresh,
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+					}
+
+					data, err := json.Marshal(info)
+					if err != nil {
+						t.Fatal(err)
 					}
 
 					cursor := new(MockArangoCursor)
 					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:  "test-key",
+						Code: code,
+						Data: data,
+					}, driver.DocumentMeta{}, nil)
 
 					db := new(MockArangoDB)
 					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
 
 					return db
 				},
-				collection: DefaultTokenStoreCollection,
 			},
 			args: args{
-				ctx:     context.Background(),
-				refresh: "test-refresh-token",
+				ctx:  context.Background(),
+				code: "test-code",
 			},
+			want: &models.Token{ClientID: "client-id", UserID: "user-id", Code: "test-code"},
 		},
 		{
-			name: "remove token by refresh token with query error",
+			name: "consume unknown code",
 			fields: fields{
-				db: func(ctx context.Context, refresh string) driver.Database {
-					query := "FOR doc IN @@collection FILTER doc.refresh_token == @refresh_token REMOVE doc IN @@collection"
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(false)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.Anything, mock.Anything).Return(cursor, nil)
+
+					return db
+				},
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: "test-code",
+			},
+			wantErr:   true,
+			wantErrIs: ErrTokenNotFound,
+		},
+		{
+			name: "consume code keeping consumed code",
+			fields: fields{
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					query := "FOR doc IN @@collection FILTER doc.code == @code UPDATE doc WITH { consumed_at: NOT_NULL(doc.consumed_at, @consumed_at) } IN @@collection RETURN OLD"
 					bindVars := map[string]interface{}{
-						"@collection":   DefaultTokenStoreCollection,
-						"refresh_token": refresh,
+						"@collection": DefaultTokenStoreCollection,
+						"code":        code,
+						"consumed_at": now,
 					}
 
+					data, err := json.Marshal(info)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:  "test-key",
+						Code: code,
+						Data: data,
+					}, driver.DocumentMeta{}, nil)
+
 					db := new(MockArangoDB)
-					db.On("Query", ctx, query, bindVars).Return(nil, fmt.Errorf("error"))
+					db.On("Query", ctx, query, bindVars).Return(cursor, nil)
 
 					return db
 				},
-				collection: DefaultTokenStoreCollection,
+				revokeCodeReuse: true,
 			},
 			args: args{
-				ctx:     context.Background(),
-				refresh: DefaultTokenStoreCollection,
+				ctx:  context.Background(),
+				code: "test-code",
 			},
-			wantErr: true,
+			want: &models.Token{ClientID: "client-id", UserID: "user-id", Code: "test-code"},
+		},
+		{
+			name: "consume reused code",
+			fields: fields{
+				db: func(ctx context.Context, code string, info oauth2.TokenInfo) driver.Database {
+					data, err := json.Marshal(&models.Token{ClientID: "client-id", UserID: "user-id", Code: code})
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					cursor := new(MockArangoCursor)
+					cursor.On("Close").Return(nil)
+					cursor.On("HasMore").Return(true).Once()
+					cursor.On("HasMore").Return(false).Once()
+					cursor.On("ReadDocument", ctx, mock.Anything).Return(&TokenStoreItem{
+						Key:        "test-key",
+						Code:       code,
+						Data:       data,
+						ConsumedAt: consumedAt,
+					}, driver.DocumentMeta{}, nil)
+
+					revoked := new(MockArangoCursor)
+					revoked.On("Close").Return(nil)
+
+					db := new(MockArangoDB)
+					db.On("Query", ctx, mock.MatchedBy(func(query string) bool {
+						return strings.Contains(query, "UPDATE")
+					}), mock.Anything).Return(cursor, nil)
+					db.On("Query", ctx, "FOR doc IN @@collection FILTER doc.code_key == @code_key REMOVE doc IN @@collection", map[string]any{
+						"@collection": DefaultTokenStoreCollection,
+						"code_key":    "test-key",
+					}).Return(revoked, nil).Once()
+
+					return db
+				},
+				revokeCodeReuse: true,
+			},
+			args: args{
+				ctx:  context.Background(),
+				code: "test-code",
+			},
+			wantErr:   true,
+			wantErrIs: ErrCodeReuse,
 		},
 	}
 	for _, tt := range tests {
@@ -885,11 +2428,125 @@ func TestTokenStore_RemoveByRefresh(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 			s := &TokenStore{
-				db:         tt.fields.db(context.Background(), tt.args.refresh),
-				collection: tt.fields.collection,
+				db:              tt.fields.db(tt.args.ctx, tt.args.code, tt.want),
+				collection:      DefaultTokenStoreCollection,
+				clock:           clock,
+				revokeCodeReuse: tt.fields.revokeCodeReuse,
 			}
-			if err := s.RemoveByRefresh(tt.args.ctx, tt.args.refresh); (err != nil) != tt.wantErr {
-				t.Errorf("RemoveByRefresh() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := s.ConsumeByCode(tt.args.ctx, tt.args.code)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ConsumeByCode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("ConsumeByCode() error = %v, wantErrIs %v", err, tt.wantErrIs)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConsumeByCode() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenStore_HashedRefreshWithManager(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []TokenStoreOption
+		cfg  *manage.RefreshingConfig
+	}{
+		{
+			name: "refresh generating a new refresh token",
+			cfg:  manage.DefaultRefreshTokenCfg,
+		},
+		{
+			name: "refresh keeping the refresh token",
+			cfg: &manage.RefreshingConfig{
+				IsRemoveAccess:     true,
+				IsRemoveRefreshing: true,
+			},
+		},
+		{
+			name: "refresh keeping the old refresh token",
+			cfg: &manage.RefreshingConfig{
+				IsGenerateRefresh: true,
+				IsRemoveAccess:    true,
+			},
+		},
+		{
+			name: "refresh with rotation",
+			opts: []TokenStoreOption{WithTokenStoreRefreshRotation(time.Minute)},
+			cfg:  manage.DefaultRefreshTokenCfg,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := arangotest.NewDatabase("test")
+
+			tokenStore, err := NewTokenStore(append([]TokenStoreOption{
+				WithTokenStoreDatabase(db),
+				WithTokenStoreEnsureSchema(nil),
+				WithTokenStoreHashKey([]byte("secret-key")),
+			}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientStore, err := NewClientStore(
+				WithClientStoreDatabase(db),
+				WithClientStoreEnsureSchema(nil),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := clientStore.Create(&models.Client{ID: "client-id", Secret: "client-secret"}); err != nil {
+				t.Fatal(err)
+			}
+
+			m := manage.NewDefaultManager()
+			m.MapTokenStorage(tokenStore)
+			m.MapClientStorage(clientStore)
+			m.SetRefreshTokenCfg(tt.cfg)
+
+			old, err := m.GenerateAccessToken(ctx, oauth2.PasswordCredentials, &oauth2.TokenGenerateRequest{
+				ClientID:     "client-id",
+				ClientSecret: "client-secret",
+				UserID:       "user-id",
+			})
+			if err != nil {
+				t.Fatalf("GenerateAccessToken() error = %v", err)
+			}
+
+			info, err := m.RefreshAccessToken(ctx, &oauth2.TokenGenerateRequest{
+				ClientID:     "client-id",
+				ClientSecret: "client-secret",
+				Refresh:      old.GetRefresh(),
+			})
+			if err != nil {
+				t.Fatalf("RefreshAccessToken() error = %v", err)
+			}
+
+			if _, err := m.LoadAccessToken(ctx, old.GetAccess()); err == nil {
+				t.Errorf("LoadAccessToken() of the old access token error = %v, want error", err)
+			}
+
+			if _, err := m.LoadAccessToken(ctx, info.GetAccess()); err != nil {
+				t.Errorf("LoadAccessToken() of the new access token error = %v", err)
+			}
+
+			// The manager clears the refresh token of the returned token if it
+			// was kept.
+			refresh := info.GetRefresh()
+			if refresh == "" {
+				refresh = old.GetRefresh()
+			}
+
+			if _, err := m.LoadRefreshToken(ctx, refresh); err != nil {
+				t.Errorf("LoadRefreshToken() of the new refresh token error = %v", err)
 			}
 		})
 	}
@@ -0,0 +1,102 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	arangoDriver "github.com/arangodb/go-driver"
+)
+
+// abortTimeout bounds the abort of a transaction.
+const abortTimeout = 5 * time.Second
+
+// transactionKey is the context key marking that a stream transaction is in
+// progress.
+type transactionKey struct{}
+
+// withTransaction runs fn in a stream transaction writing the given
+// collections. The transaction is committed if fn returns nil, otherwise it is
+// aborted. If the context is already bound to a transaction started by the
+// package, fn joins that transaction instead.
+//
+// The transaction is committed as well if fn fails because a token was reused,
+// so the revocation of the tokens issued from it is not rolled back.
+func withTransaction(ctx context.Context, db arangoDriver.Database, collections []string, fn func(ctx context.Context) error) (err error) {
+	if ctx.Value(transactionKey{}) != nil {
+		return fn(ctx)
+	}
+
+	tid, err := db.BeginTransaction(ctx, arangoDriver.TransactionCollections{Write: collections}, nil)
+	if err != nil {
+		return &StoreError{Op: "BeginTransaction", Collection: strings.Join(collections, ","), Cause: err}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = abortTransaction(db, tid)
+			panic(r)
+		}
+	}()
+
+	txCtx := context.WithValue(arangoDriver.WithTransactionID(ctx, tid), transactionKey{}, tid)
+
+	if err := fn(txCtx); err != nil {
+		if revokedOnReuse(err) {
+			if commitErr := db.CommitTransaction(ctx, tid, nil); commitErr != nil {
+				return fmt.Errorf("%w; commit transaction: %v", err, commitErr)
+			}
+
+			return err
+		}
+
+		if abortErr := abortTransaction(db, tid); abortErr != nil {
+			return fmt.Errorf("%w; abort transaction: %v", err, abortErr)
+		}
+
+		return err
+	}
+
+	if err := db.CommitTransaction(ctx, tid, nil); err != nil {
+		return &StoreError{Op: "CommitTransaction", Collection: strings.Join(collections, ","), Cause: err}
+	}
+
+	return nil
+}
+
+// revokedOnReuse reports whether the error is returned after revoking tokens
+// because a token was reused.
+func revokedOnReuse(err error) bool {
+	return errors.Is(err, ErrRefreshTokenReuse) || errors.Is(err, ErrCodeReuse)
+}
+
+// abortTransaction aborts the transaction. As the transaction is usually
+// aborted because its context expired, the abort has a context of its own, so
+// the transaction does not hold its locks until the server times it out.
+func abortTransaction(db arangoDriver.Database, tid arangoDriver.TransactionID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	return db.AbortTransaction(ctx, tid, nil)
+}
+
+// WithTransaction runs fn in a stream transaction on the collection of the
+// store. The calls to the store made using the context passed to fn are part
+// of the transaction, which is committed if fn returns nil and aborted
+// otherwise. Calling WithTransaction using the context of a transaction joins
+// the transaction. If fn returns ErrRefreshTokenReuse or ErrCodeReuse, the
+// transaction is committed, so the tokens revoked on the reuse stay revoked.
+//
+// For example, the refresh flow of the go-oauth2 manager, which creates the
+// new token and removes the old one in separate calls, is made atomic by
+// calling Manager.RefreshAccessToken from fn.
+func (s *TokenStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	ctx, op := s.telemetry.start(ctx, "WithTransaction", s.collection)
+	defer op.end(&err)
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return withTransaction(ctx, s.db, []string{s.collection}, fn)
+}
@@ -0,0 +1,218 @@
+package arangostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/arangodb/go-driver"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTokenStore_WithTransaction(t *testing.T) {
+	errFn := fmt.Errorf("fn error")
+	cols := driver.TransactionCollections{Write: []string{DefaultTokenStoreCollection}}
+
+	tests := []struct {
+		name      string
+		db        func(ctx context.Context) driver.Database
+		fn        func(s *TokenStore) func(ctx context.Context) error
+		wantErr   bool
+		wantErrIs error
+	}{
+		{
+			name: "commit transaction",
+			db: func(ctx context.Context) driver.Database {
+				cursor := new(MockArangoCursor)
+				cursor.On("Close").Return(nil)
+
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil)
+				db.On("Query", mock.MatchedBy(func(ctx context.Context) bool {
+					return ctx.Value(transactionKey{}) == driver.TransactionID("tid")
+				}), mock.Anything, mock.Anything).Return(cursor, nil)
+				db.On("CommitTransaction", ctx, driver.TransactionID("tid"), (*driver.CommitTransactionOptions)(nil)).Return(nil)
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return s.RemoveByAccess(ctx, "test-access-token")
+				}
+			},
+		},
+		{
+			name: "abort transaction",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil)
+				db.On("AbortTransaction", mock.Anything, driver.TransactionID("tid"), (*driver.AbortTransactionOptions)(nil)).Return(nil)
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return errFn
+				}
+			},
+			wantErr:   true,
+			wantErrIs: errFn,
+		},
+		{
+			name: "commit transaction on refresh token reuse",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil)
+				db.On("CommitTransaction", ctx, driver.TransactionID("tid"), (*driver.CommitTransactionOptions)(nil)).Return(nil)
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return &StoreError{Op: "GetByRefresh", Collection: s.collection, Cause: ErrRefreshTokenReuse}
+				}
+			},
+			wantErr:   true,
+			wantErrIs: ErrRefreshTokenReuse,
+		},
+		{
+			name: "commit transaction on code reuse with commit error",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil)
+				db.On("CommitTransaction", ctx, driver.TransactionID("tid"), (*driver.CommitTransactionOptions)(nil)).Return(fmt.Errorf("error"))
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return ErrCodeReuse
+				}
+			},
+			wantErr:   true,
+			wantErrIs: ErrCodeReuse,
+		},
+		{
+			name: "abort transaction with abort error",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil)
+				db.On("AbortTransaction", mock.Anything, driver.TransactionID("tid"), (*driver.AbortTransactionOptions)(nil)).Return(fmt.Errorf("error"))
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return errFn
+				}
+			},
+			wantErr:   true,
+			wantErrIs: errFn,
+		},
+		{
+			name: "join transaction",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID("tid"), nil).Once()
+				db.On("CommitTransaction", ctx, driver.TransactionID("tid"), (*driver.CommitTransactionOptions)(nil)).Return(nil).Once()
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					return s.WithTransaction(ctx, func(ctx context.Context) error {
+						return nil
+					})
+				}
+			},
+		},
+		{
+			name: "begin transaction with error",
+			db: func(ctx context.Context) driver.Database {
+				db := new(MockArangoDB)
+				db.On("BeginTransaction", ctx, cols, (*driver.BeginTransactionOptions)(nil)).Return(driver.TransactionID(""), fmt.Errorf("error"))
+
+				return db
+			},
+			fn: func(s *TokenStore) func(ctx context.Context) error {
+				return func(ctx context.Context) error {
+					t.Error("fn called without transaction")
+					return nil
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := tt.db(ctx)
+			s := &TokenStore{
+				db:         db,
+				collection: DefaultTokenStoreCollection,
+			}
+			err := s.WithTransaction(ctx, tt.fn(s))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("WithTransaction() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("WithTransaction() error = %v, wantErrIs %v", err, tt.wantErrIs)
+			}
+			db.(*MockArangoDB).AssertExpectations(t)
+		})
+	}
+}
+
+func TestTokenStore_WithTransaction_Panic(t *testing.T) {
+	ctx := context.Background()
+
+	db := new(MockArangoDB)
+	db.On("BeginTransaction", ctx, mock.Anything, mock.Anything).Return(driver.TransactionID("tid"), nil)
+	db.On("AbortTransaction", mock.Anything, driver.TransactionID("tid"), (*driver.AbortTransactionOptions)(nil)).Return(nil)
+
+	s := &TokenStore{
+		db:         db,
+		collection: DefaultTokenStoreCollection,
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("WithTransaction() did not panic")
+		}
+		db.AssertExpectations(t)
+	}()
+
+	_ = s.WithTransaction(ctx, func(ctx context.Context) error {
+		panic("fn panic")
+	})
+}
+
+func TestTokenStore_WithTransaction_Expired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := new(MockArangoDB)
+	db.On("BeginTransaction", ctx, mock.Anything, mock.Anything).Return(driver.TransactionID("tid"), nil)
+	db.On("AbortTransaction", mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Err() == nil
+	}), driver.TransactionID("tid"), (*driver.AbortTransactionOptions)(nil)).Return(nil)
+
+	s := &TokenStore{
+		db:         db,
+		collection: DefaultTokenStoreCollection,
+	}
+
+	err := s.WithTransaction(ctx, func(txCtx context.Context) error {
+		cancel()
+		return txCtx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WithTransaction() error = %v, want %v", err, context.Canceled)
+	}
+
+	db.AssertExpectations(t)
+}